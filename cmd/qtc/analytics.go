@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"time"
+
+	"github.com/xaverhimmelsbach/quadtree-block-compression/pkg/utils"
+)
+
+func directoryExists(directory string) (bool, error) {
+	if _, err := os.Stat(directory); err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		} else {
+			return true, err
+		}
+	}
+	return true, nil
+}
+
+// addAnalyticsFiles adds the input and output files of a command to analyticsFiles
+func addAnalyticsFiles(analyticsFiles *map[string]io.Reader, inputPath string, outputPath string, output io.Reader) error {
+	inputFilename := "input" + path.Ext(inputPath)
+	outputFilename := "output" + path.Ext(outputPath)
+
+	inputFile, err := os.Open(inputPath)
+	if err != nil {
+		return err
+	}
+
+	(*analyticsFiles)[inputFilename] = inputFile
+	(*analyticsFiles)[outputFilename] = output
+	return nil
+}
+
+func writeAnalytics(analyticsFiles *map[string]io.Reader, analyticsDir string, analyticsEnabled bool) error {
+	if analyticsEnabled && len(analyticsDir) > 0 {
+		// Create sub directory with current timestamp for currentAnalytics
+		timestamp := fmt.Sprint(time.Now().Unix())
+		currentAnalyticsDir := path.Join(analyticsDir, timestamp)
+
+		// Try to create valid directory, if one already exists for the current timestamp by appending a number
+		i := 0
+
+		exists, err := directoryExists(currentAnalyticsDir)
+		for exists {
+			if err != nil {
+				return err
+			}
+
+			currentAnalyticsDir = path.Join(analyticsDir, fmt.Sprintf("%s_%d", timestamp, i))
+			i = i + 1
+
+			// A bit dumb to have this line twice, but err must be checked...
+			exists, err = directoryExists(currentAnalyticsDir)
+		}
+
+		err = os.MkdirAll(currentAnalyticsDir, 0755)
+		if err != nil {
+			return err
+		}
+
+		// Write encoding analytics if appropriate
+		if len(*analyticsFiles) > 0 {
+			for filename, reader := range *analyticsFiles {
+				filepath := path.Join(currentAnalyticsDir, filename)
+				err = utils.WriteFile(filepath, reader)
+				if err != nil {
+					return err
+				}
+			}
+
+			fmt.Printf("Wrote analytics files to %s\n", currentAnalyticsDir)
+		}
+	}
+
+	return nil
+}
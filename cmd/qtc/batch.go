@@ -0,0 +1,378 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/xaverhimmelsbach/quadtree-block-compression/pkg/config"
+	"github.com/xaverhimmelsbach/quadtree-block-compression/pkg/utils"
+)
+
+// Extensions of the files encoded when walking input directories
+var batchExtensions = map[string]bool{
+	".jpg": true, ".jpeg": true, ".png": true, ".gif": true, ".bmp": true, ".tif": true, ".tiff": true, ".webp": true,
+}
+
+// Extensions appended to the names of encoded files per archive format
+var archiveExtensions = map[string]string{
+	"gzip": ".tar.gz",
+	"zip":  ".zip",
+}
+
+// batchJob is a single file to encode in batch mode
+type batchJob struct {
+	inputPath  string
+	outputPath string
+}
+
+// batchResult holds the outcome of a batchJob for the summary
+type batchResult struct {
+	batchJob
+	// Was the job skipped because its output was up to date?
+	skipped    bool
+	inputSize  int64
+	outputSize int64
+	duration   time.Duration
+	err        error
+}
+
+// runBatch encodes many images with a pool of workers, mirroring the directory structure of the inputs into an output directory
+func runBatch(args []string) error {
+	flags := flag.NewFlagSet("batch", flag.ExitOnError)
+	outputDir := flags.String("output", "", "Directory to write encoded files to")
+	configPath := flags.String("config", "", "Path to read program config from, the built-in defaults are used if empty")
+	preset := flags.String("preset", "", "Built-in preset the config is based on, replaces the preset of the config file")
+	jobs := flags.Int("jobs", runtime.GOMAXPROCS(0), "Number of images encoded concurrently")
+	force := flags.Bool("force", false, "Encode images even if their output is newer than the input")
+	summaryPath := flags.String("summary", "", "Path to additionally write the summary to as CSV")
+	timeout := flags.Duration("timeout", 0, "Abort encoding a single image after this duration, e.g. 5m. No limit if 0")
+	configFlags := config.RegisterFlags(flags)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: qtc batch -output <dir> [flags] <directory or glob>...")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if *outputDir == "" || flags.NArg() == 0 {
+		return errors.New("-output and at least one input directory or glob are required")
+	}
+	if *jobs < 1 {
+		return fmt.Errorf("-jobs has to be at least 1, got %d", *jobs)
+	}
+
+	cfg, err := loadConfig(*configPath, *preset, configFlags)
+	if err != nil {
+		return err
+	}
+
+	// Visualizations are only written by single encodes
+	cfg.VisualizationConfig.Enable = false
+
+	batchJobs, err := collectBatchJobs(flags.Args(), *outputDir, archiveExtensions[cfg.Encoding.ArchiveFormat])
+	if err != nil {
+		return err
+	}
+
+	// Interrupts cancel the running jobs and fail the remaining ones
+	ctx, stop := newCommandContext(0)
+	defer stop()
+
+	// Feed jobs to a fixed number of workers
+	start := time.Now()
+	jobQueue := make(chan batchJob)
+	results := make([]batchResult, 0, len(batchJobs))
+	var resultsMutex sync.Mutex
+	var wg sync.WaitGroup
+
+	for i := 0; i < *jobs; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobQueue {
+				result := runBatchJob(ctx, job, cfg, *force, *timeout)
+
+				resultsMutex.Lock()
+				results = append(results, result)
+				resultsMutex.Unlock()
+			}
+		}()
+	}
+
+	for _, job := range batchJobs {
+		jobQueue <- job
+	}
+	close(jobQueue)
+	wg.Wait()
+
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].inputPath < results[j].inputPath
+	})
+
+	failed := printBatchSummary(results, time.Since(start))
+
+	if *summaryPath != "" {
+		err = writeBatchSummary(results, *summaryPath)
+		if err != nil {
+			return err
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d files failed", failed, len(results))
+	}
+	return nil
+}
+
+// collectBatchJobs expands directories and globs into jobs. Outputs mirror the path of the inputs relative to the directory or the static part of the glob.
+func collectBatchJobs(inputs []string, outputDir string, outputExtension string) ([]batchJob, error) {
+	var batchJobs []batchJob
+	seen := make(map[string]bool)
+
+	addJob := func(baseDir string, inputPath string) error {
+		if seen[inputPath] {
+			return nil
+		}
+		seen[inputPath] = true
+
+		relativePath, err := filepath.Rel(baseDir, inputPath)
+		if err != nil {
+			return err
+		}
+
+		batchJobs = append(batchJobs, batchJob{
+			inputPath:  inputPath,
+			outputPath: filepath.Join(outputDir, relativePath) + outputExtension,
+		})
+		return nil
+	}
+
+	for _, input := range inputs {
+		info, err := os.Stat(input)
+		if err == nil && info.IsDir() {
+			// Walk directories and pick up all image files
+			err = filepath.WalkDir(input, func(path string, entry fs.DirEntry, err error) error {
+				if err != nil {
+					return err
+				}
+				if entry.IsDir() || !batchExtensions[strings.ToLower(filepath.Ext(path))] {
+					return nil
+				}
+				return addJob(input, path)
+			})
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		matches, err := filepath.Glob(input)
+		if err != nil {
+			return nil, err
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("%s matches no files", input)
+		}
+
+		baseDir := getGlobBaseDir(input)
+		for _, match := range matches {
+			if info, err := os.Stat(match); err == nil && info.IsDir() {
+				continue
+			}
+
+			err = addJob(baseDir, match)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return batchJobs, nil
+}
+
+// getGlobBaseDir returns the directory of pattern up to the first element containing wildcards
+func getGlobBaseDir(pattern string) string {
+	elements := strings.Split(filepath.ToSlash(pattern), "/")
+
+	// The last element is the file name itself
+	baseElements := make([]string, 0, len(elements))
+	for _, element := range elements[:len(elements)-1] {
+		if strings.ContainsAny(element, "*?[\\") {
+			break
+		}
+		baseElements = append(baseElements, element)
+	}
+
+	baseDir := filepath.FromSlash(strings.Join(baseElements, "/"))
+	if baseDir == "" && strings.HasPrefix(pattern, "/") {
+		return "/"
+	}
+	if baseDir == "" {
+		return "."
+	}
+	return baseDir
+}
+
+// runBatchJob encodes a single file, turning panics into errors so that other jobs keep running.
+// Encoding is aborted once ctx is cancelled or timeout has passed, if it is greater than 0.
+func runBatchJob(ctx context.Context, job batchJob, cfg *config.Config, force bool, timeout time.Duration) (result batchResult) {
+	result.batchJob = job
+	start := time.Now()
+
+	defer func() {
+		if r := recover(); r != nil {
+			result.err = fmt.Errorf("%v", r)
+		}
+		result.duration = time.Since(start)
+	}()
+
+	inputInfo, err := os.Stat(job.inputPath)
+	if err != nil {
+		result.err = err
+		return result
+	}
+	result.inputSize = inputInfo.Size()
+
+	// Skip files whose output is newer than the input
+	if outputInfo, err := os.Stat(job.outputPath); err == nil && !force && !outputInfo.ModTime().Before(inputInfo.ModTime()) {
+		result.skipped = true
+		result.outputSize = outputInfo.Size()
+		return result
+	}
+
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	encoded, _, err := encodeFile(ctx, job.inputPath, cfg)
+	if err != nil {
+		result.err = getCancellationError(err, timeout)
+		return result
+	}
+
+	err = os.MkdirAll(filepath.Dir(job.outputPath), 0755)
+	if err != nil {
+		result.err = err
+		return result
+	}
+
+	err = utils.WriteFile(job.outputPath, encoded)
+	if err != nil {
+		result.err = err
+		return result
+	}
+
+	outputInfo, err := os.Stat(job.outputPath)
+	if err != nil {
+		result.err = err
+		return result
+	}
+	result.outputSize = outputInfo.Size()
+
+	return result
+}
+
+// getStatus returns a short description of the outcome of the job
+func (r batchResult) getStatus() string {
+	switch {
+	case r.err != nil:
+		return "failed"
+	case r.skipped:
+		return "skipped"
+	default:
+		return "encoded"
+	}
+}
+
+// getRatio returns the size of the output relative to the input
+func (r batchResult) getRatio() float64 {
+	if r.inputSize == 0 {
+		return 0
+	}
+	return float64(r.outputSize) / float64(r.inputSize)
+}
+
+// printBatchSummary prints a line per result and the totals, returning the number of failed jobs.
+// elapsed is the wall time of the whole batch, which is shorter than the summed time of the jobs if several ran at once.
+func printBatchSummary(results []batchResult, elapsed time.Duration) int {
+	writer := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(writer, "Input\tStatus\tInput size\tOutput size\tRatio\tTime\tError")
+
+	var encoded, skipped, failed int
+	var inputSize, outputSize int64
+	var jobDuration time.Duration
+
+	for _, result := range results {
+		errorMessage := ""
+		switch result.getStatus() {
+		case "failed":
+			failed++
+			errorMessage = result.err.Error()
+		case "skipped":
+			skipped++
+		default:
+			encoded++
+			inputSize += result.inputSize
+			outputSize += result.outputSize
+		}
+		jobDuration += result.duration
+
+		fmt.Fprintf(writer, "%s\t%s\t%d\t%d\t%.3f\t%s\t%s\n", result.inputPath, result.getStatus(), result.inputSize, result.outputSize, result.getRatio(), result.duration.Round(time.Millisecond), errorMessage)
+	}
+	writer.Flush()
+
+	totalRatio := 0.0
+	if inputSize > 0 {
+		totalRatio = float64(outputSize) / float64(inputSize)
+	}
+
+	fmt.Printf("\n%d encoded, %d skipped, %d failed, ratio of encoded files %.3f, elapsed time %s, summed job time %s\n", encoded, skipped, failed, totalRatio, elapsed.Round(time.Millisecond), jobDuration.Round(time.Millisecond))
+	return failed
+}
+
+// writeBatchSummary writes a line per result to a CSV file at path
+func writeBatchSummary(results []batchResult, path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	writer := csv.NewWriter(file)
+	writer.Write([]string{"input", "output", "status", "input_size", "output_size", "ratio", "seconds", "error"})
+
+	for _, result := range results {
+		errorMessage := ""
+		if result.err != nil {
+			errorMessage = result.err.Error()
+		}
+
+		writer.Write([]string{
+			result.inputPath,
+			result.outputPath,
+			result.getStatus(),
+			strconv.FormatInt(result.inputSize, 10),
+			strconv.FormatInt(result.outputSize, 10),
+			strconv.FormatFloat(result.getRatio(), 'f', 3, 64),
+			strconv.FormatFloat(result.duration.Seconds(), 'f', 3, 64),
+			errorMessage,
+		})
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
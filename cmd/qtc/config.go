@@ -0,0 +1,61 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"os"
+
+	"github.com/xaverhimmelsbach/quadtree-block-compression/pkg/config"
+	"github.com/xaverhimmelsbach/quadtree-block-compression/pkg/quadtreeImage"
+	"gopkg.in/yaml.v3"
+)
+
+// loadConfig reads the config file at configPath on top of preset, or the built-in defaults if both are empty, applies the config flags given on the command line and validates the result
+func loadConfig(configPath string, preset string, configFlags *config.Flags) (*config.Config, error) {
+	cfg, err := config.LoadConfig(configPath, preset)
+	if err != nil {
+		return nil, err
+	}
+
+	err = configFlags.Apply(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	err = cfg.Validate(quadtreeImage.ConfigOptions())
+	if err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+// runConfig prints the effective config after merging the built-in defaults, the preset, the config file and the command line flags
+func runConfig(args []string) error {
+	flags := flag.NewFlagSet("config", flag.ExitOnError)
+	configPath := flags.String("config", "", "Path to read program config from, the built-in defaults are used if empty")
+	preset := flags.String("preset", "", "Built-in preset the config is based on, replaces the preset of the config file")
+	configFlags := config.RegisterFlags(flags)
+	flags.Parse(args)
+
+	cfg, err := loadConfig(*configPath, *preset, configFlags)
+	if err != nil {
+		return err
+	}
+
+	return writeConfig(os.Stdout, cfg)
+}
+
+// writeConfig writes cfg to writer as YAML
+func writeConfig(writer io.Writer, cfg *config.Config) error {
+	// Match the indentation of configs/config.yml
+	encoder := yaml.NewEncoder(writer)
+	encoder.SetIndent(2)
+
+	err := encoder.Encode(cfg)
+	if err != nil {
+		return err
+	}
+
+	return encoder.Close()
+}
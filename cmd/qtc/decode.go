@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/xaverhimmelsbach/quadtree-block-compression/pkg/config"
+	"github.com/xaverhimmelsbach/quadtree-block-compression/pkg/quadtreeImage"
+	"github.com/xaverhimmelsbach/quadtree-block-compression/pkg/utils"
+)
+
+// runDecode decodes a quadtree file into an image file
+func runDecode(args []string) error {
+	flags := flag.NewFlagSet("decode", flag.ExitOnError)
+	inputPath := flags.String("input", "", "Quadtree file to decode")
+	outputPath := flags.String("output", "", "Path to write decoded image to")
+	configPath := flags.String("config", "", "Path to read program config from, the built-in defaults are used if empty")
+	analyticsDir := flags.String("analyticsDir", "", "Directory to write analytics to")
+	thumbnailSize := flags.Int("thumbnailSize", 0, "Decode at a reduced resolution with this length of the longer side, at most the one of the original image")
+	format := flags.String("format", "", "Image format of the decoded file (png, jpeg, bmp or tiff), overrides the extension of output")
+	preset := flags.String("preset", "", "Built-in preset the config is based on, replaces the preset of the config file")
+	timeout := flags.Duration("timeout", 0, "Abort decoding after this duration, e.g. 5m. No limit if 0")
+	configFlags := config.RegisterFlags(flags)
+	flags.Parse(args)
+
+	if *inputPath == "" || *outputPath == "" {
+		return errors.New("-input and -output are required")
+	}
+
+	// Load config and apply overrides from the command line
+	cfg, err := loadConfig(*configPath, *preset, configFlags)
+	if err != nil {
+		return err
+	}
+
+	if *format != "" {
+		cfg.Decoding.Output.Format = *format
+	}
+
+	if *thumbnailSize > 0 {
+		return decodeThumbnail(*inputPath, *outputPath, *thumbnailSize, cfg)
+	}
+
+	// Stop decoding on interrupts and after the timeout
+	ctx, cancel := newCommandContext(*timeout)
+	defer cancel()
+
+	ctx, finishProgress := withProgressBar(ctx)
+	decoded, analyticsFiles, err := quadtreeImage.Decode(ctx, *inputPath, *outputPath, cfg)
+	finishProgress()
+	if err != nil {
+		return getCancellationError(err, *timeout)
+	}
+
+	// Create clone of decoded to write it to analytics as well
+	var decodedClone bytes.Buffer
+	decodedTee := io.TeeReader(decoded, &decodedClone)
+
+	// decodedTee has to be read before decodedClone
+	err = utils.WriteFile(*outputPath, decodedTee)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Decoded %s and wrote it to %s\n", *inputPath, *outputPath)
+
+	// Write input and output files to analytics
+	if cfg.VisualizationConfig.Enable {
+		err = addAnalyticsFiles(analyticsFiles, *inputPath, *outputPath, &decodedClone)
+		if err != nil {
+			return err
+		}
+	}
+
+	return writeAnalytics(analyticsFiles, *analyticsDir, cfg.VisualizationConfig.Enable)
+}
+
+// decodeThumbnail decodes the quadtree file at inputPath at a reduced resolution and writes it to outputPath
+func decodeThumbnail(inputPath string, outputPath string, thumbnailSize int, cfg *config.Config) error {
+	inputFile, err := os.Open(inputPath)
+	if err != nil {
+		return err
+	}
+	defer inputFile.Close()
+
+	thumbnail, err := quadtreeImage.DecodeScaled(inputFile, thumbnailSize, cfg)
+	if err != nil {
+		return err
+	}
+
+	outputFormat, err := quadtreeImage.GetOutputFormat(cfg, outputPath)
+	if err != nil {
+		return err
+	}
+
+	writeOptions, err := quadtreeImage.GetWriteOptions(cfg)
+	if err != nil {
+		return err
+	}
+
+	err = utils.WriteImageToFile(thumbnail, outputPath, outputFormat, writeOptions)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Decoded %s as a thumbnail and wrote it to %s\n", inputPath, outputPath)
+	return nil
+}
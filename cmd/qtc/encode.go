@@ -0,0 +1,245 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"image"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/h2non/filetype"
+	"github.com/xaverhimmelsbach/quadtree-block-compression/pkg/config"
+	"github.com/xaverhimmelsbach/quadtree-block-compression/pkg/quadtreeImage"
+	"github.com/xaverhimmelsbach/quadtree-block-compression/pkg/utils"
+)
+
+// runEncode encodes an image file as quadtree image
+func runEncode(args []string) error {
+	flags := flag.NewFlagSet("encode", flag.ExitOnError)
+	inputPath := flags.String("input", "", "Image or quadtree file to encode")
+	outputPath := flags.String("output", "", "Path to write encoded file to")
+	configPath := flags.String("config", "", "Path to read program config from, the built-in defaults are used if empty")
+	analyticsDir := flags.String("analyticsDir", "", "Directory to write analytics to")
+	preset := flags.String("preset", "", "Built-in preset the config is based on, replaces the preset of the config file")
+	timeout := flags.Duration("timeout", 0, "Abort encoding after this duration, e.g. 5m. No limit if 0")
+	tiled := flags.Bool("tiled", false, "Encode the image tile by tile and write the archive while encoding. Only PGM and PPM files are read tile by tile, other images are decoded as a whole first")
+	configFlags := config.RegisterFlags(flags)
+	flags.Parse(args)
+
+	if *inputPath == "" || *outputPath == "" {
+		return errors.New("-input and -output are required")
+	}
+
+	// Load config and apply overrides from the command line
+	cfg, err := loadConfig(*configPath, *preset, configFlags)
+	if err != nil {
+		return err
+	}
+
+	// Stop encoding on interrupts and after the timeout
+	ctx, cancel := newCommandContext(*timeout)
+	defer cancel()
+
+	ctx, finishProgress := withProgressBar(ctx)
+
+	if *tiled {
+		err = encodeFileTiled(ctx, *inputPath, *outputPath, cfg)
+		finishProgress()
+		if err != nil {
+			return getCancellationError(err, *timeout)
+		}
+
+		fmt.Printf("Encoded %s tile by tile as a quadtree image and wrote it to %s\n", *inputPath, *outputPath)
+		return nil
+	}
+
+	encoded, analyticsFiles, err := encodeFile(ctx, *inputPath, cfg)
+	finishProgress()
+	if err != nil {
+		return getCancellationError(err, *timeout)
+	}
+
+	// Create clone of encoded to write it to analytics as well
+	var encodedClone bytes.Buffer
+	encodedTee := io.TeeReader(encoded, &encodedClone)
+
+	// encodedTee has to be read before encodedClone
+	err = utils.WriteFile(*outputPath, encodedTee)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Encoded %s as a quadtree image and wrote it to %s\n", *inputPath, *outputPath)
+
+	// Write input and output files to analytics
+	if cfg.VisualizationConfig.Enable {
+		err = addAnalyticsFiles(analyticsFiles, *inputPath, *outputPath, &encodedClone)
+		if err != nil {
+			return err
+		}
+	}
+
+	return writeAnalytics(analyticsFiles, *analyticsDir, cfg.VisualizationConfig.Enable)
+}
+
+// encodeFile encodes the image or quadtree file at inputPath as quadtree image.
+// Decoding, partitioning and encoding stop once ctx is cancelled.
+func encodeFile(ctx context.Context, inputPath string, cfg *config.Config) (io.Reader, *map[string]io.Reader, error) {
+	img, imageMetadata, err := readInputImage(ctx, inputPath, cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Create quadtree image representation
+	quadtreeRoot, err := quadtreeImage.NewQuadtreeImage(img, cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if cfg.Encoding.Metadata.Preserve {
+		quadtreeRoot.SetImageMetadata(imageMetadata)
+	}
+
+	// Partition image into a quadtree structure
+	err = quadtreeRoot.Partition(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Encode quadtree structure
+	return quadtreeRoot.Encode(ctx, quadtreeImage.ArchiveMode(cfg.Encoding.ArchiveFormat))
+}
+
+// encodeFileTiled encodes the image or quadtree file at inputPath tile by tile and writes the archive to outputPath while encoding.
+// PGM and PPM files are read tile by tile as well, other files are decoded as a whole first, so only their quadtree is limited to a tile.
+func encodeFileTiled(ctx context.Context, inputPath string, outputPath string, cfg *config.Config) (err error) {
+	if cfg.VisualizationConfig.Enable {
+		return errors.New("visualizations need the whole quadtree and can't be created by tiled encoding")
+	}
+
+	var source quadtreeImage.TileSource
+	var imageMetadata *utils.ImageMetadata
+	if isPNMFile(inputPath) {
+		pnmFile, err := utils.OpenPNM(inputPath)
+		if err != nil {
+			return err
+		}
+		defer pnmFile.Close()
+
+		source = pnmFile
+	} else {
+		img, inputMetadata, err := readInputImage(ctx, inputPath, cfg)
+		if err != nil {
+			return err
+		}
+
+		source = quadtreeImage.NewImageTileSource(img)
+		imageMetadata = inputMetadata
+	}
+
+	if !cfg.Encoding.Metadata.Preserve {
+		imageMetadata = nil
+	}
+
+	outputFile, err := os.Create(outputPath)
+	if err != nil {
+		return err
+	}
+
+	// Don't leave incomplete archives behind
+	defer func() {
+		closeErr := outputFile.Close()
+		if err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			os.Remove(outputPath)
+		}
+	}()
+
+	writer := bufio.NewWriter(outputFile)
+	err = quadtreeImage.EncodeTiles(ctx, source, writer, cfg, imageMetadata)
+	if err != nil {
+		return err
+	}
+
+	return writer.Flush()
+}
+
+// readInputImage reads the image or quadtree file at inputPath together with the metadata of the image, applying its EXIF orientation if configured
+func readInputImage(ctx context.Context, inputPath string, cfg *config.Config) (image.Image, *utils.ImageMetadata, error) {
+	// PGM and PPM files are only read tile by tile, don't load them as a whole just to reject them
+	if isPNMFile(inputPath) {
+		return nil, nil, fmt.Errorf("%s is a PGM or PPM file, which can only be encoded with -tiled", inputPath)
+	}
+
+	// TODO: Reuse buffer for image reading
+	inputBuffer, err := ioutil.ReadFile(inputPath)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	switch {
+	case filetype.IsImage(inputBuffer):
+	case filetype.IsArchive(inputBuffer):
+		// Decode quadtree files losslessly to re-encode them with the current config
+		inputBuffer, err = decodeForReencoding(ctx, inputPath, cfg)
+		if err != nil {
+			return nil, nil, err
+		}
+	default:
+		return nil, nil, fmt.Errorf("%s is neither a supported image (%s, or %s with -tiled) nor a quadtree file", inputPath, strings.Join(utils.SupportedInputFormats, ", "), strings.Join(utils.SupportedTiledInputFormats, ", "))
+	}
+
+	// Read image from input buffer
+	img, err := utils.ReadImageFromBytes(inputBuffer)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Extract EXIF, ICC profile and XMP metadata
+	imageMetadata, err := utils.ReadImageMetadata(inputBuffer)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Rotate pixels according to the EXIF orientation and reset it, so that it isn't applied twice
+	if cfg.Encoding.Metadata.ApplyOrientation {
+		img = utils.ApplyOrientation(img, imageMetadata.Orientation())
+		imageMetadata.SetOrientation(1)
+	}
+
+	return img, imageMetadata, nil
+}
+
+// isPNMFile returns whether path has the extension of a PGM or PPM file
+func isPNMFile(path string) bool {
+	extension := strings.ToLower(filepath.Ext(path))
+	for _, pnmExtension := range utils.PNMExtensions {
+		if extension == pnmExtension {
+			return true
+		}
+	}
+	return false
+}
+
+// decodeForReencoding decodes the quadtree file at inputPath into a PNG file, keeping the metadata of the original image
+func decodeForReencoding(ctx context.Context, inputPath string, cfg *config.Config) ([]byte, error) {
+	decodingConfig := *cfg
+	decodingConfig.Decoding.Output.Format = utils.FormatPNG
+	decodingConfig.VisualizationConfig.Enable = false
+
+	decoded, _, err := quadtreeImage.Decode(ctx, inputPath, "", &decodingConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	return ioutil.ReadAll(decoded)
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/xaverhimmelsbach/quadtree-block-compression/pkg/quadtreeImage"
+)
+
+// runInfo prints the structure of a quadtree file without decoding its blocks
+func runInfo(args []string) error {
+	flags := flag.NewFlagSet("info", flag.ExitOnError)
+	inputPath := flags.String("input", "", "Quadtree file to inspect")
+	flags.Parse(args)
+
+	if *inputPath == "" {
+		return errors.New("-input is required")
+	}
+
+	inputFile, err := os.Open(*inputPath)
+	if err != nil {
+		return err
+	}
+	defer inputFile.Close()
+
+	info, err := quadtreeImage.Inspect(inputFile)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Dimensions:  %dx%d\n", info.Width, info.Height)
+	fmt.Printf("Color model: %s\n", info.ColorModel)
+	if info.RequestedColorSpace != info.ColorSpace {
+		fmt.Printf("Color space: %s (%s was requested, but its planes only hold opaque 8-bit RGB images)\n", info.ColorSpace, info.RequestedColorSpace)
+	} else {
+		fmt.Printf("Color space: %s\n", info.ColorSpace)
+	}
+	fmt.Printf("Alpha:       %t\n", info.HasAlpha)
+	fmt.Printf("Metadata:    %s\n", formatImageMetadata(info))
+
+	for _, tree := range info.Trees {
+		fmt.Println()
+		if tree.Name != "" {
+			fmt.Printf("Plane %s\n", tree.Name)
+		}
+
+		fmt.Printf("Tree height: %d\n", tree.TreeHeight)
+		fmt.Printf("Leaves:      %d (%d blocks, %d references, %d of them transformed)\n", tree.Leaves(), tree.Blocks, tree.References, tree.TransformedReferences)
+		fmt.Printf("Dedup ratio: %.2f%%\n", tree.DedupRatio()*100)
+		fmt.Println("Leaves per depth:")
+
+		// Leaves at depth d cover (2^(height-d) * BlockSize)^2 pixels
+		for depth, count := range tree.LeavesPerDepth {
+			leafSize := quadtreeImage.BlockSize << (tree.TreeHeight - depth)
+			fmt.Printf("  %2d (%5dpx): %d\n", depth, leafSize, count)
+		}
+	}
+
+	return nil
+}
+
+// formatImageMetadata lists the metadata blocks of the original image file stored in a quadtree file
+func formatImageMetadata(info *quadtreeImage.Info) string {
+	var blocks []string
+	if info.HasExif {
+		blocks = append(blocks, "EXIF")
+	}
+	if info.HasICCProfile {
+		blocks = append(blocks, "ICC profile")
+	}
+	if info.HasXMP {
+		blocks = append(blocks, "XMP")
+	}
+
+	if len(blocks) == 0 {
+		return "none"
+	}
+	return strings.Join(blocks, ", ")
+}
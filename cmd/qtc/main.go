@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+)
+
+// command is a subcommand of qtc
+type command struct {
+	// Short description shown in the usage message
+	description string
+	// Parses the arguments following the name of the subcommand and executes it
+	run func(args []string) error
+}
+
+// commands holds all subcommands of qtc by name
+var commands = map[string]command{
+	"encode": {description: "Encode an image or re-encode a quadtree file", run: runEncode},
+	"batch":  {description: "Encode directories or globs of images concurrently", run: runBatch},
+	"config": {description: "Print the effective config after applying defaults and flags", run: runConfig},
+	"decode": {description: "Decode a quadtree file into an image", run: runDecode},
+	"preset": {description: "List the built-in presets or show the config values of one", run: runPreset},
+	"info":   {description: "Print the structure of a quadtree file without decoding it", run: runInfo},
+	"verify": {description: "Check the integrity of a quadtree file", run: runVerify},
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		printUsage()
+		os.Exit(2)
+	}
+
+	name := os.Args[1]
+	cmd, ok := commands[name]
+	if !ok {
+		if name == "help" || name == "-h" || name == "-help" || name == "--help" {
+			printUsage()
+			return
+		}
+
+		fmt.Fprintf(os.Stderr, "qtc: unknown command %q\n", name)
+		printUsage()
+		os.Exit(2)
+	}
+
+	err := runCommand(cmd, os.Args[2:])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "qtc %s: %v\n", name, err)
+		os.Exit(1)
+	}
+}
+
+// runCommand executes cmd and turns panics of the quadtree packages into errors
+func runCommand(cmd command, args []string) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+
+	return cmd.run(args)
+}
+
+// printUsage writes the list of subcommands to stderr
+func printUsage() {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintln(os.Stderr, "Usage: qtc <command> [flags]")
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "Commands:")
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-8s %s\n", name, commands[name].description)
+	}
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "Run qtc <command> -h to list the flags of a command.")
+}
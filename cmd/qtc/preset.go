@@ -0,0 +1,52 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/xaverhimmelsbach/quadtree-block-compression/pkg/config"
+	"github.com/xaverhimmelsbach/quadtree-block-compression/pkg/quadtreeImage"
+)
+
+// runPreset lists the built-in presets or shows the effective config a single one expands to
+func runPreset(args []string) error {
+	if len(args) == 0 {
+		return errors.New("expected a subcommand: list or show <preset>")
+	}
+
+	switch args[0] {
+	case "list":
+		writer := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		for _, preset := range config.GetPresets() {
+			fmt.Fprintf(writer, "%s\t%s\n", preset.Name, preset.Description)
+		}
+		return writer.Flush()
+	case "show":
+		if len(args) != 2 {
+			return errors.New("expected the name of a preset: show <preset>")
+		}
+
+		preset, err := config.GetPreset(args[1])
+		if err != nil {
+			return err
+		}
+
+		// Show all values, including the defaults the preset doesn't change
+		cfg, err := preset.Expand()
+		if err != nil {
+			return err
+		}
+
+		err = cfg.Validate(quadtreeImage.ConfigOptions())
+		if err != nil {
+			return fmt.Errorf("preset %s: %w", preset.Name, err)
+		}
+
+		fmt.Printf("# %s\n", preset.Description)
+		return writeConfig(os.Stdout, cfg)
+	default:
+		return fmt.Errorf("unknown subcommand %q, expected list or show <preset>", args[0])
+	}
+}
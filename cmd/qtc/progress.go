@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"github.com/xaverhimmelsbach/quadtree-block-compression/pkg/quadtreeImage"
+)
+
+const (
+	// Number of characters of the bar itself
+	progressBarWidth = 30
+	// Minimal time between two redraws of the progress bar
+	progressBarInterval = 100 * time.Millisecond
+)
+
+// progressBar renders the progress of encoding and decoding on a terminal, one line per stage
+type progressBar struct {
+	writer io.Writer
+	// Stage of the line currently drawn
+	stage string
+	// Time of the last redraw
+	lastDraw time.Time
+}
+
+// newCommandContext returns a context that is cancelled on interrupts and, if timeout is greater than 0, once timeout has passed
+func newCommandContext(timeout time.Duration) (context.Context, context.CancelFunc) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	if timeout <= 0 {
+		return ctx, stop
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	return ctx, func() {
+		cancel()
+		stop()
+	}
+}
+
+// getCancellationError replaces the errors of cancelled contexts with a description of what caused the cancellation
+func getCancellationError(err error, timeout time.Duration) error {
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return fmt.Errorf("did not finish within %s", timeout)
+	case errors.Is(err, context.Canceled):
+		return errors.New("interrupted")
+	default:
+		return err
+	}
+}
+
+// withProgressBar returns a copy of ctx that draws a progress bar on stdout if stdout is a terminal.
+// The returned function ends the last line of the bar and has to be called before printing anything else.
+func withProgressBar(ctx context.Context) (context.Context, func()) {
+	if !isTerminal(os.Stdout) {
+		return ctx, func() {}
+	}
+
+	bar := &progressBar{writer: os.Stdout}
+	return quadtreeImage.WithProgress(ctx, bar.update), bar.finish
+}
+
+// isTerminal returns whether file is attached to a terminal
+func isTerminal(file *os.File) bool {
+	info, err := file.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+// update redraws the bar if enough time has passed since the last redraw or a stage started or finished
+func (b *progressBar) update(progress quadtreeImage.Progress) {
+	isNewStage := progress.Stage != b.stage
+	isFinished := progress.NodesProcessed >= progress.EstimatedTotal
+	if !isNewStage && !isFinished && time.Since(b.lastDraw) < progressBarInterval {
+		return
+	}
+
+	// Keep the line of the previous stage
+	if isNewStage && b.stage != "" {
+		fmt.Fprintln(b.writer)
+	}
+	b.stage = progress.Stage
+	b.lastDraw = time.Now()
+
+	share := 1.0
+	if progress.EstimatedTotal > 0 {
+		share = float64(progress.NodesProcessed) / float64(progress.EstimatedTotal)
+	}
+	filled := int(share * progressBarWidth)
+
+	// Return to the start of the line and clear it before drawing
+	fmt.Fprintf(b.writer, "\r\033[K%-9s [%s%s] %3.0f%% %d/%d nodes, depth %d",
+		progress.Stage,
+		strings.Repeat("#", filled),
+		strings.Repeat("-", progressBarWidth-filled),
+		100*share,
+		progress.NodesProcessed,
+		progress.EstimatedTotal,
+		progress.Depth,
+	)
+}
+
+// finish ends the line of the bar if anything was drawn
+func (b *progressBar) finish() {
+	if b.stage != "" {
+		fmt.Fprintln(b.writer)
+		b.stage = ""
+	}
+}
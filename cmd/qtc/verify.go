@@ -0,0 +1,38 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/xaverhimmelsbach/quadtree-block-compression/pkg/quadtreeImage"
+)
+
+// runVerify checks the integrity of a quadtree file and fails if any problem was found
+func runVerify(args []string) error {
+	flags := flag.NewFlagSet("verify", flag.ExitOnError)
+	inputPath := flags.String("input", "", "Quadtree file to verify")
+	flags.Parse(args)
+
+	if *inputPath == "" {
+		return errors.New("-input is required")
+	}
+
+	inputFile, err := os.Open(*inputPath)
+	if err != nil {
+		return err
+	}
+	defer inputFile.Close()
+
+	problems := quadtreeImage.Verify(inputFile)
+	if len(problems) > 0 {
+		for _, problem := range problems {
+			fmt.Fprintln(os.Stderr, problem)
+		}
+		return fmt.Errorf("%s is corrupted, found %d problems", *inputPath, len(problems))
+	}
+
+	fmt.Printf("%s is intact\n", *inputPath)
+	return nil
+}
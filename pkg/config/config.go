@@ -1,6 +1,9 @@
 package config
 
 import (
+	"bytes"
+	"errors"
+	"io"
 	"os"
 
 	"gopkg.in/yaml.v3"
@@ -9,10 +12,16 @@ import (
 type QuadtreeConfig struct {
 	// Minimal similarity of base and upsampled image required to be a leaf
 	SimilarityCutoff float64 `yaml:"SimilarityCutoff"`
+	// Metric measuring the similarity of base and upsampled image (Weighted or Exact)
+	SimilarityMetric string `yaml:"SimilarityMetric"`
 	// Interpolation algorithm used to downsample base image
 	DownsamplingInterpolator string `yaml:"DownsamplingInterpolator"`
 	// Interpolation algorithm used to upsample downsampled image
 	UpsamplingInterpolator string `yaml:"UpsamplingInterpolator"`
+	// Color space the image is partitioned in
+	ColorSpace string `yaml:"ColorSpace"`
+	// Minimal similarity per plane of planar color spaces, planes that are missing use SimilarityCutoff
+	PlaneSimilarityCutoffs map[string]float64 `yaml:"PlaneSimilarityCutoffs"`
 }
 
 type SkipOutOfBoundsBlocksConfig struct {
@@ -25,20 +34,76 @@ type DeduplicateBlocksConfig struct {
 	Enable bool `yaml:"Enable"`
 	// How similar do blocks have to be to be deduplicated
 	MinimalSimilarity float64 `yaml:"MinimalSimilarity"`
+	// Should blocks also be deduplicated with flipped, rotated and uniformly brightened or darkened versions of earlier blocks?
+	Transforms bool `yaml:"Transforms"`
+}
+
+type MetadataConfig struct {
+	// Should EXIF, ICC profile and XMP metadata of the input file be stored in the encoded file?
+	Preserve bool `yaml:"Preserve"`
+	// Should the EXIF orientation be applied to the pixels before encoding instead of being kept as metadata?
+	ApplyOrientation bool `yaml:"ApplyOrientation"`
+}
+
+type TilingConfig struct {
+	// Edge length in pixels of the subtrees that tiled encoding keeps in memory at once, a power of two of at least 8.
+	// Nodes larger than a tile are always partitioned.
+	TileSize int `yaml:"TileSize"`
 }
 
 type EncodingConfig struct {
 	//Underlying archive format of the encoded file
 	ArchiveFormat string `yaml:"ArchiveFormat"`
+	// Image format of the blocks (auto or png), auto uses JPEG for opaque 8-bit blocks and PNG otherwise
+	BlockFormat string `yaml:"BlockFormat"`
+	// Quality of JPEG blocks (1 to 100)
+	BlockQuality int `yaml:"BlockQuality"`
 	// Should the program run in parallel?
-	Parallelism           bool                        `yaml:"Parallelism"`
+	Parallelism bool `yaml:"Parallelism"`
+	// Number of workers partitioning the quadtree if Parallelism is enabled, 0 uses GOMAXPROCS
+	Workers               int                         `yaml:"Workers"`
 	SkipOutOfBoundsBlocks SkipOutOfBoundsBlocksConfig `yaml:"SkipOutOfBoundsBlocks"`
 	DeduplicateBlocks     DeduplicateBlocksConfig     `yaml:"DeduplicateBlocks"`
+	Metadata              MetadataConfig              `yaml:"Metadata"`
+	Tiling                TilingConfig                `yaml:"Tiling"`
+}
+
+type DeblockingConfig struct {
+	// Should the seams between leaves be smoothed after decoding?
+	Enable bool `yaml:"Enable"`
+	// How strongly should seams be smoothed (0 to 1)?
+	Strength float64 `yaml:"Strength"`
+	// Maximal difference between pixels on both sides of a seam (0 to 255) that is still smoothed instead of treated as an edge of the image
+	Threshold float64 `yaml:"Threshold"`
+}
+
+type BoundaryAwareUpsamplingConfig struct {
+	// Should leaves be upsampled using the samples of their neighbouring leaves?
+	Enable bool `yaml:"Enable"`
+}
+
+type StreamingConfig struct {
+	// Should leaves be painted into the output image while the archive is read instead of building the whole quadtree first?
+	// Ignored if boundary aware upsampling or visualizations are enabled, as they need the whole quadtree.
+	Enable bool `yaml:"Enable"`
+}
+
+type OutputConfig struct {
+	// Image format of the decoded file (png, jpeg, bmp or tiff), empty to select it by the extension of the output path
+	Format string `yaml:"Format"`
+	// Quality of JPEG output (1 to 100), 0 for the encoder's default
+	JPEGQuality int `yaml:"JPEGQuality"`
+	// Compression level of PNG output (Default, None, BestSpeed or BestCompression)
+	PNGCompressionLevel string `yaml:"PNGCompressionLevel"`
 }
 
 type DecodingConfig struct {
 	// Should the program run in parallel?
-	Parallelism bool `yaml:"Parallelism"`
+	Parallelism             bool                          `yaml:"Parallelism"`
+	BoundaryAwareUpsampling BoundaryAwareUpsamplingConfig `yaml:"BoundaryAwareUpsampling"`
+	Deblocking              DeblockingConfig              `yaml:"Deblocking"`
+	Streaming               StreamingConfig               `yaml:"Streaming"`
+	Output                  OutputConfig                  `yaml:"Output"`
 }
 
 type VisualizationConfig struct {
@@ -48,12 +113,70 @@ type VisualizationConfig struct {
 
 // Config holds parameters that influence the partitioning and encoding process of the quadtree
 type Config struct {
+	// Built-in preset the config is based on, its values are overridden by all explicitly given values
+	Preset              string              `yaml:"Preset" flag:"-"`
 	Quadtree            QuadtreeConfig      `yaml:"Quadtree"`
 	Encoding            EncodingConfig      `yaml:"Encoding"`
 	Decoding            DecodingConfig      `yaml:"Decoding"`
 	VisualizationConfig VisualizationConfig `yaml:"Visualization"`
 }
 
+// Default returns the built-in config that is used if no config file is given
+func Default() *Config {
+	return &Config{
+		Quadtree: QuadtreeConfig{
+			SimilarityCutoff:         0.9,
+			SimilarityMetric:         "Weighted",
+			DownsamplingInterpolator: "NearestNeighbor",
+			UpsamplingInterpolator:   "CatmullRom",
+			ColorSpace:               "RGB",
+			PlaneSimilarityCutoffs:   map[string]float64{"Cb": 0.8, "Cr": 0.8},
+		},
+		Encoding: EncodingConfig{
+			ArchiveFormat: "gzip",
+			BlockFormat:   "auto",
+			BlockQuality:  75,
+			DeduplicateBlocks: DeduplicateBlocksConfig{
+				MinimalSimilarity: 0.9,
+			},
+			Metadata: MetadataConfig{
+				Preserve:         true,
+				ApplyOrientation: true,
+			},
+			Tiling: TilingConfig{
+				TileSize: 4096,
+			},
+		},
+		Decoding: DecodingConfig{
+			Deblocking: DeblockingConfig{
+				Strength:  1.0,
+				Threshold: 48,
+			},
+			Streaming: StreamingConfig{
+				Enable: true,
+			},
+			Output: OutputConfig{
+				JPEGQuality:         90,
+				PNGCompressionLevel: "Default",
+			},
+		},
+	}
+}
+
+// LoadConfig constructs a Config object from the YAML file at path, or from the built-in defaults if path is empty.
+// A non-empty preset replaces the preset selected by the file.
+func LoadConfig(path string, preset string) (*Config, error) {
+	var cfgBytes []byte
+	if path != "" {
+		var err error
+		cfgBytes, err = os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return newConfig(cfgBytes, preset)
+}
+
 // NewConfigFromFile constructs a Config object from a YAML file
 func NewConfigFromFile(path string) (*Config, error) {
 	cfgBytes, err := os.ReadFile(path)
@@ -63,9 +186,61 @@ func NewConfigFromFile(path string) (*Config, error) {
 	return NewConfigFromBytes(cfgBytes)
 }
 
-// NewConfigFromBytes constructs a Config object from a YAML string
+// NewConfigFromBytes constructs a Config object from a YAML string.
+// Fields missing from the YAML string keep the values of its preset or Default, unknown keys are rejected.
 func NewConfigFromBytes(cfgBytes []byte) (*Config, error) {
-	cfg := new(Config)
-	err := yaml.Unmarshal(cfgBytes, cfg)
+	return newConfig(cfgBytes, "")
+}
+
+// newConfig expands the preset and decodes cfgBytes on top of it.
+// An empty preset uses the preset selected by cfgBytes, if any.
+func newConfig(cfgBytes []byte, preset string) (*Config, error) {
+	if preset == "" {
+		// Errors are reported by the strict decoding below
+		var presetSelection struct {
+			Preset string `yaml:"Preset"`
+		}
+		yaml.Unmarshal(cfgBytes, &presetSelection)
+		preset = presetSelection.Preset
+	}
+
+	cfg := Default()
+	if preset != "" {
+		p, err := GetPreset(preset)
+		if err != nil {
+			return nil, err
+		}
+
+		cfg, err = p.Expand()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err := decodeYAML(cfg, cfgBytes)
+	cfg.Preset = preset
 	return cfg, err
 }
+
+// decodeYAML decodes cfgBytes into cfg, keeping the current values of all fields that are missing from cfgBytes.
+// Unknown keys are rejected.
+func decodeYAML(cfg *Config, cfgBytes []byte) error {
+	// Maps would otherwise be merged with the current values instead of replacing them
+	planeSimilarityCutoffs := cfg.Quadtree.PlaneSimilarityCutoffs
+	cfg.Quadtree.PlaneSimilarityCutoffs = nil
+
+	decoder := yaml.NewDecoder(bytes.NewReader(cfgBytes))
+	decoder.KnownFields(true)
+
+	err := decoder.Decode(cfg)
+	// Empty files keep all current values
+	if errors.Is(err, io.EOF) {
+		err = nil
+	}
+
+	if cfg.Quadtree.PlaneSimilarityCutoffs == nil {
+		cfg.Quadtree.PlaneSimilarityCutoffs = planeSimilarityCutoffs
+	}
+
+	return err
+}
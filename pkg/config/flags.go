@@ -0,0 +1,223 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Flags exposes every field of Config as command line flag that overrides the value of the config file, except for fields tagged with flag:"-".
+// Flags are named after the path of their YAML keys in kebab case, e.g. -quadtree.similarity-cutoff.
+type Flags struct {
+	fields []*fieldFlag
+}
+
+// fieldFlag is a flag.Value that holds the value of a single Config field until it is applied
+type fieldFlag struct {
+	// Index sequence of the field inside of Config, as used by reflect.Value.FieldByIndex
+	index []int
+	// Type of the field
+	fieldType reflect.Type
+	// Value given on the command line
+	value string
+	// Was the flag given on the command line?
+	isSet bool
+}
+
+// String returns the value given on the command line
+func (f *fieldFlag) String() string {
+	if f == nil {
+		return ""
+	}
+	return f.value
+}
+
+// Set parses value to make sure it fits the type of the field and stores it
+func (f *fieldFlag) Set(value string) error {
+	err := setFieldValue(reflect.New(f.fieldType).Elem(), value)
+	if err != nil {
+		return err
+	}
+
+	f.value = value
+	f.isSet = true
+	return nil
+}
+
+// IsBoolFlag allows boolean flags to be given without a value
+func (f *fieldFlag) IsBoolFlag() bool {
+	return f.fieldType.Kind() == reflect.Bool
+}
+
+// RegisterFlags defines a flag for every field of Config on flagSet, using the values of Default as documented defaults
+func RegisterFlags(flagSet *flag.FlagSet) *Flags {
+	flags := new(Flags)
+	flags.register(flagSet, reflect.ValueOf(Default()).Elem(), nil, "")
+	return flags
+}
+
+// register recursively defines flags for all fields of the struct value.
+// yamlPath is the path of value's YAML keys, e.g. Quadtree.
+func (f *Flags) register(flagSet *flag.FlagSet, value reflect.Value, index []int, yamlPath string) {
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Type().Field(i)
+		// Fields that need to be known before the config file is read get dedicated flags
+		if field.Tag.Get("flag") == "-" {
+			continue
+		}
+
+		fieldPath := getYAMLKey(field)
+		if yamlPath != "" {
+			fieldPath = yamlPath + "." + fieldPath
+		}
+		fieldIndex := append(append([]int{}, index...), i)
+
+		if field.Type.Kind() == reflect.Struct {
+			f.register(flagSet, value.Field(i), fieldIndex, fieldPath)
+			continue
+		}
+
+		fieldFlag := &fieldFlag{index: fieldIndex, fieldType: field.Type}
+		f.fields = append(f.fields, fieldFlag)
+
+		// Backquoted words are shown as the name of the flag's argument
+		argument := ""
+		switch field.Type.Kind() {
+		case reflect.Bool:
+		case reflect.Map:
+			argument = " with `key=value,...`"
+		default:
+			argument = fmt.Sprintf(" with a `%s`", field.Type.Kind())
+		}
+
+		usage := fmt.Sprintf("Overrides %s of the config file%s", fieldPath, argument)
+		if defaultValue := formatFieldValue(value.Field(i)); defaultValue != "" {
+			usage += fmt.Sprintf(" (default %s)", defaultValue)
+		}
+		flagSet.Var(fieldFlag, getFlagName(fieldPath), usage)
+	}
+}
+
+// getFlagName converts the path of a field's YAML keys into the name of its flag, e.g. Quadtree.SimilarityCutoff becomes quadtree.similarity-cutoff
+func getFlagName(yamlPath string) string {
+	keys := strings.Split(yamlPath, ".")
+	for i, key := range keys {
+		keys[i] = toKebabCase(key)
+	}
+	return strings.Join(keys, ".")
+}
+
+// Apply sets all fields of cfg whose flags were given on the command line
+func (f *Flags) Apply(cfg *Config) error {
+	cfgValue := reflect.ValueOf(cfg).Elem()
+	for _, fieldFlag := range f.fields {
+		if !fieldFlag.isSet {
+			continue
+		}
+
+		err := setFieldValue(cfgValue.FieldByIndex(fieldFlag.index), fieldFlag.value)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// getYAMLKey returns the key of a struct field in YAML files
+func getYAMLKey(field reflect.StructField) string {
+	key := strings.Split(field.Tag.Get("yaml"), ",")[0]
+	if key == "" {
+		return field.Name
+	}
+	return key
+}
+
+// toKebabCase converts a CamelCase name into kebab case, keeping acronyms together (JPEGQuality becomes jpeg-quality)
+func toKebabCase(name string) string {
+	runes := []rune(name)
+	var builder strings.Builder
+
+	for i, r := range runes {
+		if i > 0 && unicode.IsUpper(r) {
+			previousIsLower := unicode.IsLower(runes[i-1]) || unicode.IsDigit(runes[i-1])
+			nextIsLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if previousIsLower || (unicode.IsUpper(runes[i-1]) && nextIsLower) {
+				builder.WriteRune('-')
+			}
+		}
+		builder.WriteRune(unicode.ToLower(r))
+	}
+
+	return builder.String()
+}
+
+// setFieldValue parses value according to the type of field and assigns it.
+// Maps are given as comma-separated key=value pairs and replace the whole map.
+func setFieldValue(field reflect.Value, value string) error {
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Bool:
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		field.SetBool(parsed)
+	case reflect.Int:
+		parsed, err := strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(parsed))
+	case reflect.Float64:
+		parsed, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return err
+		}
+		field.SetFloat(parsed)
+	case reflect.Map:
+		parsed := reflect.MakeMap(field.Type())
+		for _, pair := range strings.Split(value, ",") {
+			if pair == "" {
+				continue
+			}
+
+			key, elementValue, ok := strings.Cut(pair, "=")
+			if !ok {
+				return fmt.Errorf("%q is not a key=value pair", pair)
+			}
+
+			element := reflect.New(field.Type().Elem()).Elem()
+			err := setFieldValue(element, elementValue)
+			if err != nil {
+				return err
+			}
+			parsed.SetMapIndex(reflect.ValueOf(key), element)
+		}
+		field.Set(parsed)
+	default:
+		return fmt.Errorf("unsupported config field type %s", field.Type())
+	}
+
+	return nil
+}
+
+// formatFieldValue formats the value of a config field in the syntax accepted by setFieldValue
+func formatFieldValue(field reflect.Value) string {
+	if field.Kind() != reflect.Map {
+		return fmt.Sprintf("%v", field.Interface())
+	}
+
+	pairs := make([]string, 0, field.Len())
+	for _, key := range field.MapKeys() {
+		pairs = append(pairs, fmt.Sprintf("%v=%v", key.Interface(), field.MapIndex(key).Interface()))
+	}
+	sort.Strings(pairs)
+
+	return strings.Join(pairs, ",")
+}
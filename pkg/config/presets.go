@@ -0,0 +1,153 @@
+package config
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Preset is a named set of config values tuned for a type of image
+type Preset struct {
+	// Name used to select the preset
+	Name string
+	// Short description of the images the preset is tuned for
+	Description string
+	// Config values of the preset as YAML, all other fields keep their defaults
+	YAML string
+}
+
+// presets holds the built-in presets by name
+var presets = map[string]Preset{
+	"photo": {
+		Name:        "photo",
+		Description: "Natural images with smooth gradients, favours size over sharpness",
+		YAML: `Quadtree:
+  SimilarityCutoff: 0.9
+  SimilarityMetric: Weighted
+  DownsamplingInterpolator: BiLinear
+  UpsamplingInterpolator: CatmullRom
+  ColorSpace: YCbCr420
+  PlaneSimilarityCutoffs:
+    Cb: 0.8
+    Cr: 0.8
+Encoding:
+  BlockFormat: auto
+  BlockQuality: 80
+  DeduplicateBlocks:
+    Enable: False
+Decoding:
+  BoundaryAwareUpsampling:
+    Enable: True
+  Deblocking:
+    Enable: True
+`,
+	},
+	"screenshot": {
+		Name:        "screenshot",
+		Description: "User interfaces with flat areas and hard edges, keeps edges sharp and reuses repeated and mirrored blocks",
+		YAML: `Quadtree:
+  SimilarityCutoff: 0.97
+  SimilarityMetric: Exact
+  DownsamplingInterpolator: NearestNeighbor
+  UpsamplingInterpolator: NearestNeighbor
+  ColorSpace: RGB
+Encoding:
+  BlockFormat: png
+  DeduplicateBlocks:
+    Enable: True
+    MinimalSimilarity: 0.99
+    Transforms: True
+Decoding:
+  BoundaryAwareUpsampling:
+    Enable: False
+  Deblocking:
+    Enable: False
+`,
+	},
+	"text": {
+		Name:        "text",
+		Description: "Scanned or rendered text, preserves glyph edges and deduplicates recurring glyphs",
+		YAML: `Quadtree:
+  SimilarityCutoff: 0.98
+  SimilarityMetric: Weighted
+  DownsamplingInterpolator: NearestNeighbor
+  UpsamplingInterpolator: NearestNeighbor
+  ColorSpace: RGB
+Encoding:
+  BlockFormat: png
+  DeduplicateBlocks:
+    Enable: True
+    MinimalSimilarity: 0.97
+Decoding:
+  BoundaryAwareUpsampling:
+    Enable: False
+  Deblocking:
+    Enable: False
+`,
+	},
+	"archival": {
+		Name:        "archival",
+		Description: "Long-term storage, stays as close to the original as possible and keeps all metadata untouched",
+		YAML: `Quadtree:
+  SimilarityCutoff: 0.995
+  SimilarityMetric: Exact
+  DownsamplingInterpolator: CatmullRom
+  UpsamplingInterpolator: CatmullRom
+  ColorSpace: RGB
+Encoding:
+  BlockFormat: png
+  DeduplicateBlocks:
+    Enable: True
+    MinimalSimilarity: 1
+  Metadata:
+    Preserve: True
+    ApplyOrientation: False
+Decoding:
+  BoundaryAwareUpsampling:
+    Enable: False
+  Deblocking:
+    Enable: False
+`,
+	},
+}
+
+// GetPreset returns the built-in preset called name
+func GetPreset(name string) (Preset, error) {
+	preset, ok := presets[name]
+	if !ok {
+		return preset, fmt.Errorf("preset not found: %q, available presets are %s", name, strings.Join(getPresetNames(), ", "))
+	}
+	return preset, nil
+}
+
+// GetPresets returns all built-in presets sorted by name
+func GetPresets() []Preset {
+	names := getPresetNames()
+	sortedPresets := make([]Preset, 0, len(names))
+	for _, name := range names {
+		sortedPresets = append(sortedPresets, presets[name])
+	}
+	return sortedPresets
+}
+
+// getPresetNames returns the sorted names of all built-in presets
+func getPresetNames() []string {
+	names := make([]string, 0, len(presets))
+	for name := range presets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Expand returns the config the preset expands to if no other values are given
+func (p Preset) Expand() (*Config, error) {
+	cfg := Default()
+	err := decodeYAML(cfg, []byte(p.YAML))
+	if err != nil {
+		return nil, fmt.Errorf("preset %s: %w", p.Name, err)
+	}
+
+	cfg.Preset = p.Name
+	return cfg, nil
+}
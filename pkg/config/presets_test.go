@@ -0,0 +1,39 @@
+package config_test
+
+import (
+	"testing"
+
+	"github.com/xaverhimmelsbach/quadtree-block-compression/pkg/config"
+	"github.com/xaverhimmelsbach/quadtree-block-compression/pkg/quadtreeImage"
+)
+
+func TestPresetsExpandToValidConfigs(t *testing.T) {
+	for _, preset := range config.GetPresets() {
+		cfg, err := preset.Expand()
+		if err != nil {
+			t.Fatalf("preset %s: %v", preset.Name, err)
+		}
+
+		if err := cfg.Validate(quadtreeImage.ConfigOptions()); err != nil {
+			t.Errorf("preset %s: %v", preset.Name, err)
+		}
+		if cfg.Quadtree.SimilarityMetric == "" {
+			t.Errorf("preset %s doesn't choose a similarity metric", preset.Name)
+		}
+	}
+}
+
+func TestConfigValuesOverridePreset(t *testing.T) {
+	cfg, err := config.NewConfigFromBytes([]byte("Preset: screenshot\nQuadtree:\n  SimilarityMetric: Weighted\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.Quadtree.SimilarityMetric != "Weighted" {
+		t.Errorf("SimilarityMetric = %q, want the value of the config file", cfg.Quadtree.SimilarityMetric)
+	}
+	// Values missing from the config file keep the values of the preset
+	if cfg.Quadtree.SimilarityCutoff != 0.97 {
+		t.Errorf("SimilarityCutoff = %v, want the value of the preset", cfg.Quadtree.SimilarityCutoff)
+	}
+}
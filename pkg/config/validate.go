@@ -0,0 +1,121 @@
+package config
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/xaverhimmelsbach/quadtree-block-compression/pkg/utils"
+)
+
+// Options holds the values accepted by the options that select one of the implementations registered by the codec.
+// The codec depends on this package, so it provides them to Validate, see quadtreeImage.ConfigOptions.
+type Options struct {
+	// Interpolation algorithms that can be used for down- and upsampling
+	Interpolators []string
+	// Metrics measuring the similarity of base and upsampled image
+	SimilarityMetrics []string
+	// Color spaces images can be partitioned in
+	ColorSpaces []string
+	// Planes of the planar color spaces
+	Planes []string
+	// Archive formats encoded files can be written in
+	ArchiveFormats []string
+	// Image formats blocks can be written in
+	BlockFormats []string
+}
+
+// ValidationError lists every invalid field of a Config
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("invalid config:\n  %s", strings.Join(e.Problems, "\n  "))
+}
+
+// Validate checks all fields of the config and reports every invalid one at once.
+// Options selecting an implementation are checked against the values in options.
+func (c *Config) Validate(options Options) error {
+	v := new(validator)
+
+	v.checkRange("Quadtree.SimilarityCutoff", c.Quadtree.SimilarityCutoff, 0, 1)
+	// An empty metric selects Weighted
+	if c.Quadtree.SimilarityMetric != "" {
+		v.checkOneOf("Quadtree.SimilarityMetric", c.Quadtree.SimilarityMetric, options.SimilarityMetrics)
+	}
+	v.checkOneOf("Quadtree.DownsamplingInterpolator", c.Quadtree.DownsamplingInterpolator, options.Interpolators)
+	v.checkOneOf("Quadtree.UpsamplingInterpolator", c.Quadtree.UpsamplingInterpolator, options.Interpolators)
+	// An empty color space selects RGB
+	if c.Quadtree.ColorSpace != "" {
+		v.checkOneOf("Quadtree.ColorSpace", c.Quadtree.ColorSpace, options.ColorSpaces)
+	}
+	// Report problems in the same order on every run
+	planes := make([]string, 0, len(c.Quadtree.PlaneSimilarityCutoffs))
+	for plane := range c.Quadtree.PlaneSimilarityCutoffs {
+		planes = append(planes, plane)
+	}
+	sort.Strings(planes)
+	for _, plane := range planes {
+		v.checkOneOf("Quadtree.PlaneSimilarityCutoffs key", plane, options.Planes)
+		v.checkRange("Quadtree.PlaneSimilarityCutoffs."+plane, c.Quadtree.PlaneSimilarityCutoffs[plane], 0, 1)
+	}
+
+	v.checkOneOf("Encoding.ArchiveFormat", c.Encoding.ArchiveFormat, options.ArchiveFormats)
+	v.checkOneOf("Encoding.BlockFormat", c.Encoding.BlockFormat, options.BlockFormats)
+	// A quality of 0 selects the default quality of the encoder
+	v.checkRange("Encoding.BlockQuality", float64(c.Encoding.BlockQuality), 0, 100)
+	if c.Encoding.Workers < 0 {
+		v.addProblem("Encoding.Workers: %d is negative", c.Encoding.Workers)
+	}
+	v.checkRange("Encoding.DeduplicateBlocks.MinimalSimilarity", c.Encoding.DeduplicateBlocks.MinimalSimilarity, 0, 1)
+	// Tiles have to be partitionable down to blocks of 8 pixels
+	if tileSize := c.Encoding.Tiling.TileSize; tileSize < 8 || tileSize&(tileSize-1) != 0 {
+		v.addProblem("Encoding.Tiling.TileSize: %d is not a power of two of at least 8", tileSize)
+	}
+
+	v.checkRange("Decoding.Deblocking.Strength", c.Decoding.Deblocking.Strength, 0, 1)
+	v.checkRange("Decoding.Deblocking.Threshold", c.Decoding.Deblocking.Threshold, 0, 255)
+	// An empty format selects the format by the extension of the output path
+	if c.Decoding.Output.Format != "" {
+		if _, err := utils.GetFormat(c.Decoding.Output.Format); err != nil {
+			v.addProblem("Decoding.Output.Format: %v", err)
+		}
+	}
+	// A quality of 0 selects the default quality of the encoder
+	v.checkRange("Decoding.Output.JPEGQuality", float64(c.Decoding.Output.JPEGQuality), 0, 100)
+	if _, err := utils.GetPNGCompressionLevel(c.Decoding.Output.PNGCompressionLevel); err != nil {
+		v.addProblem("Decoding.Output.PNGCompressionLevel: %v", err)
+	}
+
+	if len(v.problems) > 0 {
+		return &ValidationError{Problems: v.problems}
+	}
+	return nil
+}
+
+// validator collects the problems found during validation
+type validator struct {
+	problems []string
+}
+
+func (v *validator) addProblem(format string, args ...interface{}) {
+	v.problems = append(v.problems, fmt.Sprintf(format, args...))
+}
+
+// checkRange adds a problem if value is outside of the range from min to max
+func (v *validator) checkRange(field string, value float64, min float64, max float64) {
+	if value < min || value > max {
+		v.addProblem("%s: %v is not in the range from %v to %v", field, value, min, max)
+	}
+}
+
+// checkOneOf adds a problem if value isn't one of the allowed values
+func (v *validator) checkOneOf(field string, value string, allowed []string) {
+	for _, allowedValue := range allowed {
+		if value == allowedValue {
+			return
+		}
+	}
+	v.addProblem("%s: unknown value %q, expected one of %s", field, value, strings.Join(allowed, ", "))
+}
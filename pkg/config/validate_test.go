@@ -0,0 +1,45 @@
+package config
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+var testOptions = Options{
+	Interpolators:     []string{"NearestNeighbor", "CatmullRom"},
+	SimilarityMetrics: []string{"Weighted", "Exact"},
+	ColorSpaces:       []string{"RGB", "PlanarRGB"},
+	Planes:            []string{"R", "G", "B", "Cb", "Cr"},
+	ArchiveFormats:    []string{"gzip"},
+	BlockFormats:      []string{"auto"},
+}
+
+func TestValidateDefault(t *testing.T) {
+	if err := Default().Validate(testOptions); err != nil {
+		t.Fatalf("default config is invalid: %v", err)
+	}
+}
+
+func TestValidateReportsPlaneProblemsInOrder(t *testing.T) {
+	cfg := Default()
+	cfg.Quadtree.PlaneSimilarityCutoffs = map[string]float64{"Z": 0.5, "G": 2, "A": 0.5, "R": 0.5}
+
+	want := []string{
+		`Quadtree.PlaneSimilarityCutoffs key: unknown value "A", expected one of R, G, B, Cb, Cr`,
+		`Quadtree.PlaneSimilarityCutoffs.G: 2 is not in the range from 0 to 1`,
+		`Quadtree.PlaneSimilarityCutoffs key: unknown value "Z", expected one of R, G, B, Cb, Cr`,
+	}
+
+	// Map iteration order differs between runs, so validate several times
+	for i := 0; i < 20; i++ {
+		var validationError *ValidationError
+		if err := cfg.Validate(testOptions); !errors.As(err, &validationError) {
+			t.Fatalf("expected a ValidationError, got %v", err)
+		}
+
+		if !reflect.DeepEqual(validationError.Problems, want) {
+			t.Fatalf("problems = %q, want %q", validationError.Problems, want)
+		}
+	}
+}
@@ -3,6 +3,7 @@ package quadtreeImage
 import (
 	"archive/tar"
 	"archive/zip"
+	"bufio"
 	"bytes"
 	"compress/gzip"
 	"errors"
@@ -17,6 +18,9 @@ import (
 
 type ArchiveMode string
 
+// archiveHeaderLength is the number of bytes at the start of a file needed to infer its filetype
+const archiveHeaderLength = 262
+
 const (
 	ArchiveModeGzip ArchiveMode = "gzip"
 	ArchiveModeZip  ArchiveMode = "zip"
@@ -43,7 +47,7 @@ type ArchiveReader struct {
 	// Only in use with gzip compression.
 	tarReader *tar.Reader
 	// Only in use with zip compression.
-	zipReader *zip.ReadCloser
+	zipReader *zip.Reader
 	// Caches all the files contained in the archive.
 	fileCache map[string]*[]byte
 }
@@ -129,18 +133,23 @@ func (w *ArchiveWriter) Close() error {
 
 // OpenArchiveReader will open the archive file specified by name and return a reader.
 func OpenArchiveReader(name string) (*ArchiveReader, error) {
-	// Open archive to infer filetype
 	archiveFile, err := os.Open(name)
 	if err != nil {
 		return nil, err
 	}
 	defer archiveFile.Close()
 
-	archiveContents, err := ioutil.ReadAll(archiveFile)
+	return NewArchiveReader(archiveFile)
+}
+
+// NewArchiveReader reads an archive from reader and returns an ArchiveReader for its contents.
+func NewArchiveReader(reader io.Reader) (*ArchiveReader, error) {
+	archiveContents, err := ioutil.ReadAll(reader)
 	if err != nil {
 		return nil, err
 	}
 
+	// Infer filetype
 	filetype, err := filetype.Match(archiveContents)
 	if err != nil {
 		return nil, err
@@ -160,17 +169,22 @@ func OpenArchiveReader(name string) (*ArchiveReader, error) {
 
 		// Create tar reader and cache archive files
 		archiveReader.tarReader = tar.NewReader(archiveReader.gzipReader)
-		archiveReader.populateFileCacheGzip()
+		err = archiveReader.populateFileCacheGzip()
+		if err != nil {
+			return archiveReader, err
+		}
 	case ArchiveModeZip:
 		archiveReader.mode = ArchiveModeZip
-		// TODO: Unneccessary read
-		archiveReader.zipReader, err = zip.OpenReader(name)
+		archiveReader.zipReader, err = zip.NewReader(bytes.NewReader(archiveContents), int64(len(archiveContents)))
 		if err != nil {
 			return archiveReader, err
 		}
 
 		// Cache archive files
-		archiveReader.populateFileCacheZip()
+		err = archiveReader.populateFileCacheZip()
+		if err != nil {
+			return archiveReader, err
+		}
 	default:
 		return archiveReader, fmt.Errorf("no corresponding switch case found for archive type %s", filetype.MIME.Subtype)
 	}
@@ -246,3 +260,78 @@ func (r *ArchiveReader) populateFileCacheZip() error {
 
 	return nil
 }
+
+// walkArchive calls visit for every file of the archive file at path in the order they are stored, without keeping their contents in memory.
+// The reader passed to visit is only valid until visit returns.
+func walkArchive(path string, visit func(name string, contents io.Reader) error) error {
+	archiveFile, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer archiveFile.Close()
+
+	// Infer filetype from the header of the file without consuming it
+	bufferedFile := bufio.NewReader(archiveFile)
+	header, err := bufferedFile.Peek(archiveHeaderLength)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+
+	filetype, err := filetype.Match(header)
+	if err != nil {
+		return err
+	}
+
+	switch ArchiveMode(filetype.MIME.Subtype) {
+	case ArchiveModeGzip:
+		gzipReader, err := gzip.NewReader(bufferedFile)
+		if err != nil {
+			return err
+		}
+		defer gzipReader.Close()
+
+		tarReader := tar.NewReader(gzipReader)
+		for {
+			header, err := tarReader.Next()
+			if errors.Is(err, io.EOF) {
+				// Last file read
+				return nil
+			} else if err != nil {
+				return err
+			}
+
+			err = visit(header.Name, tarReader)
+			if err != nil {
+				return err
+			}
+		}
+	case ArchiveModeZip:
+		// Zip archives are read from their central directory at the end of the file
+		fileInfo, err := archiveFile.Stat()
+		if err != nil {
+			return err
+		}
+
+		zipReader, err := zip.NewReader(archiveFile, fileInfo.Size())
+		if err != nil {
+			return err
+		}
+
+		for _, file := range zipReader.File {
+			fileReader, err := file.Open()
+			if err != nil {
+				return err
+			}
+
+			err = visit(file.Name, fileReader)
+			fileReader.Close()
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	default:
+		return fmt.Errorf("no corresponding switch case found for archive type %s", filetype.MIME.Subtype)
+	}
+}
@@ -0,0 +1,312 @@
+package quadtreeImage
+
+import (
+	"image"
+	"math"
+	"sync"
+
+	"github.com/xaverhimmelsbach/quadtree-block-compression/pkg/utils"
+)
+
+// Allowance for rounding errors of distances summed up in different orders
+const blockDistanceEpsilon = 1e-9
+
+// Multiple of the tolerance of a channel above which its differences all add the same to the distance of blocks.
+// Channels that don't have to match may differ arbitrarily, so the search radius has to allow for their largest difference.
+// Capping differences moves unrelated blocks that far apart as well, which lets searches skip them. 16 times the tolerance was fastest on photos.
+const blockDistanceSaturation = 16
+
+// Weights and tolerances of the channels of color and grayscale blocks, as compared by utils.ComparePixelsWeighted
+var (
+	colorBlockTolerances = utils.WeightedColorTolerances()
+	grayBlockTolerances  = utils.WeightedGrayTolerances()
+)
+
+// blockVector holds the 16-bit channel values of a block, red, green and blue per pixel for color blocks and one per pixel for grayscale blocks
+type blockVector struct {
+	values []uint16
+	isGray bool
+}
+
+// getTolerances returns the weights and tolerances of the channels of v
+func (v blockVector) getTolerances() []utils.ChannelTolerance {
+	if v.isGray {
+		return grayBlockTolerances
+	}
+	return colorBlockTolerances
+}
+
+// offsetBlock is a block stored by its pixels without their brightness
+type offsetBlock struct {
+	block *image.Image
+	// Smallest value of the color channels of the block, which was subtracted from all of them
+	minimum int
+}
+
+// blockIndex holds the minimal blocks of all leaves of a quadtree and finds the ones that a new block can be deduplicated with.
+// Exact duplicates are found by their pixels, near-duplicates by searching all blocks within the distance that their similarity allows in a vantage point tree.
+type blockIndex struct {
+	// Blocks by their pixel data
+	exact map[string]*image.Image
+	// 8-bit blocks by their pixel data after subtracting the smallest color channel value, finds blocks that only differ in brightness
+	offsets map[string]offsetBlock
+	// Blocks by their channel values
+	tree vpTree
+	// Regulate access to exact, offsets and tree
+	mutex sync.RWMutex
+}
+
+// newBlockIndex returns an empty blockIndex
+func newBlockIndex() *blockIndex {
+	return &blockIndex{
+		exact:   make(map[string]*image.Image),
+		offsets: make(map[string]offsetBlock),
+	}
+}
+
+// findSimilar returns the stored block most similar to block and its similarity if that is at least minimalSimilarity or the block equals it, or nil
+func (b *blockIndex) findSimilar(block image.Image, minimalSimilarity float64) (*image.Image, float64) {
+	pixels, vector := getBlockSignature(block)
+
+	b.mutex.RLock()
+	if match, ok := b.exact[string(pixels)]; ok {
+		b.mutex.RUnlock()
+		// Exact duplicates are always found, even if minimalSimilarity exceeds the similarity of identical blocks
+		similarity, err := utils.ComparePixelsWeighted(block, *match, block.Bounds())
+		if err != nil {
+			panic(err)
+		}
+		return match, similarity
+	}
+	// Blocks farther away than the search radius can't be similar enough
+	candidates := b.tree.search(vector, getSearchRadius(vector, minimalSimilarity))
+	b.mutex.RUnlock()
+
+	// The weighted similarity of the candidates decides, blocks are only ever added, so they can be compared without holding the lock
+	bestSimilarity := 0.0
+	var bestBlock *image.Image
+	for _, candidate := range candidates {
+		similarity, err := utils.ComparePixelsWeighted(block, *candidate, block.Bounds())
+		if err != nil {
+			panic(err)
+		}
+
+		if similarity > bestSimilarity {
+			bestSimilarity = similarity
+			bestBlock = candidate
+		}
+	}
+
+	if bestBlock == nil || bestSimilarity < minimalSimilarity {
+		return nil, 0
+	}
+	return bestBlock, bestSimilarity
+}
+
+// findTransformed returns a stored block that block can be deduplicated with after flipping or rotating it in one of the EXIF orientations, or nil.
+// Blocks that equal block after brightening or darkening all of their color channels by the same amount are preferred, they are looked up exactly.
+// Otherwise the most similar of the reoriented blocks with a similarity of at least minimalSimilarity is returned.
+// Every orientation is searched like near-duplicates of block, so transforms should only be searched for leaves that found no near-duplicate.
+// The returned blockTransform turns the returned block into block.
+func (b *blockIndex) findTransformed(block image.Image, minimalSimilarity float64) (*image.Image, blockTransform) {
+	orientedBlocks := make([]image.Image, 8)
+	for orientation := 1; orientation <= 8; orientation++ {
+		orientedBlocks[orientation-1] = utils.ApplyOrientation(block, orientation)
+	}
+
+	// A block reoriented to match a stored block equals the stored block reoriented the other way
+	b.mutex.RLock()
+	for orientation, orientedBlock := range orientedBlocks {
+		pixels, isOffsetSupported := getBlockPixels(orientedBlock)
+		if !isOffsetSupported {
+			continue
+		}
+
+		normalizedPixels, minimum := getNormalizedPixels(pixels, orientedBlock)
+		if match, ok := b.offsets[string(normalizedPixels)]; ok {
+			b.mutex.RUnlock()
+			return match.block, blockTransform{orientation: utils.InverseOrientation(orientation + 1), offset: minimum - match.minimum}
+		}
+	}
+	b.mutex.RUnlock()
+
+	// The unchanged block was already searched by findSimilar
+	bestSimilarity := 0.0
+	var bestBlock *image.Image
+	bestTransform := identityTransform
+	for orientation, orientedBlock := range orientedBlocks[1:] {
+		if match, similarity := b.findSimilar(orientedBlock, minimalSimilarity); match != nil && similarity > bestSimilarity {
+			bestSimilarity = similarity
+			bestBlock = match
+			bestTransform = blockTransform{orientation: utils.InverseOrientation(orientation + 2)}
+		}
+	}
+
+	return bestBlock, bestTransform
+}
+
+// add stores the minimal block of a leaf unless a block with the same pixels is already stored
+func (b *blockIndex) add(block *image.Image) {
+	pixels, vector := getBlockSignature(*block)
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if _, ok := b.exact[string(pixels)]; ok {
+		return
+	}
+
+	b.exact[string(pixels)] = block
+	b.tree.insert(block, vector)
+
+	if _, isOffsetSupported := getBlockPixels(*block); isOffsetSupported {
+		normalizedPixels, minimum := getNormalizedPixels(pixels, *block)
+		if _, ok := b.offsets[string(normalizedPixels)]; !ok {
+			b.offsets[string(normalizedPixels)] = offsetBlock{block: block, minimum: minimum}
+		}
+	}
+}
+
+// getBlockSignature returns the pixel data of block and its blockVector
+func getBlockSignature(block image.Image) ([]byte, blockVector) {
+	isGray := utils.IsGray(block)
+
+	// 8-bit channels are expanded to 16 bits like by color.Color.RGBA, alpha channels are left out
+	if pixels, ok := getBlockPixels(block); ok {
+		channelCount := 4
+		if isGray {
+			channelCount = 1
+		}
+
+		values := make([]uint16, 0, len(pixels))
+		for i, channel := range pixels {
+			if isGray || i%channelCount < 3 {
+				values = append(values, uint16(channel)*0x101)
+			}
+		}
+		return pixels, blockVector{values: values, isGray: isGray}
+	}
+
+	bounds := block.Bounds()
+	pixelCount := bounds.Dx() * bounds.Dy()
+	pixels := make([]byte, 0, 8*pixelCount)
+	values := make([]uint16, 0, 3*pixelCount)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			r, g, b, a := block.At(x, y).RGBA()
+			pixels = append(pixels, byte(r>>8), byte(r), byte(g>>8), byte(g), byte(b>>8), byte(b), byte(a>>8), byte(a))
+			if isGray {
+				values = append(values, uint16(r))
+			} else {
+				values = append(values, uint16(r), uint16(g), uint16(b))
+			}
+		}
+	}
+
+	return pixels, blockVector{values: values, isGray: isGray}
+}
+
+// getBlockPixels returns the pixel buffer of 8-bit RGBA and grayscale blocks, as created by utils.Scale, and whether block is one of them
+func getBlockPixels(block image.Image) ([]byte, bool) {
+	bounds := block.Bounds()
+
+	switch img := block.(type) {
+	case *image.RGBA:
+		pixels := make([]byte, 0, 4*bounds.Dx()*bounds.Dy())
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			pixels = append(pixels, img.Pix[img.PixOffset(bounds.Min.X, y):img.PixOffset(bounds.Max.X, y)]...)
+		}
+		return pixels, true
+	case *image.Gray:
+		pixels := make([]byte, 0, bounds.Dx()*bounds.Dy())
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			pixels = append(pixels, img.Pix[img.PixOffset(bounds.Min.X, y):img.PixOffset(bounds.Max.X, y)]...)
+		}
+		return pixels, true
+	default:
+		return nil, false
+	}
+}
+
+// getNormalizedPixels returns a copy of the pixel buffer pixels of block with the smallest color channel value subtracted from all color channels, together with that value
+func getNormalizedPixels(pixels []byte, block image.Image) ([]byte, int) {
+	// Alpha channels of RGBA blocks are kept
+	channelCount := 4
+	if utils.IsGray(block) {
+		channelCount = 1
+	}
+
+	minimum := 0xff
+	for i, channel := range pixels {
+		if i%channelCount < 3 && int(channel) < minimum {
+			minimum = int(channel)
+		}
+	}
+
+	normalizedPixels := make([]byte, len(pixels))
+	for i, channel := range pixels {
+		if i%channelCount < 3 {
+			channel -= byte(minimum)
+		}
+		normalizedPixels[i] = channel
+	}
+
+	return normalizedPixels, minimum
+}
+
+// getBlockDistance returns the mean difference of the channels of two blocks, weighted like by utils.ComparePixelsWeighted.
+// Differences are capped at blockDistanceSaturation times the tolerance of their channel and scaled by that cap, so that the distance ranges from 0 to the sum of the weights.
+// Unlike the similarity, the distance is a metric, which allows searching blocks in a vantage point tree. Alpha channels are left out.
+// Blocks of different sizes or color models are infinitely far apart.
+// Once the distance exceeds limit, summing it up is stopped and a value above limit is returned.
+func getBlockDistance(a blockVector, b blockVector, limit float64) float64 {
+	if a.isGray != b.isGray || len(a.values) != len(b.values) {
+		return math.Inf(1)
+	}
+
+	tolerances := a.getTolerances()
+	pixelCount := len(a.values) / len(tolerances)
+	if pixelCount == 0 {
+		return 0
+	}
+
+	var saturations [3]int
+	var scales [3]float64
+	for channel, tolerance := range tolerances {
+		saturations[channel] = blockDistanceSaturation * int(tolerance.MaxDifference)
+		scales[channel] = tolerance.Weight / float64(saturations[channel]) / float64(pixelCount)
+	}
+
+	distance := 0.0
+	for i := 0; i < len(a.values); i += len(tolerances) {
+		for channel := range tolerances {
+			difference := int(a.values[i+channel]) - int(b.values[i+channel])
+			if difference < 0 {
+				difference = -difference
+			}
+			if difference > saturations[channel] {
+				difference = saturations[channel]
+			}
+			distance += scales[channel] * float64(difference)
+		}
+
+		if distance > limit {
+			return distance
+		}
+	}
+
+	return distance
+}
+
+// getSearchRadius returns the largest distance from a block with the blockVector vector that a block with a weighted similarity of at least minimalSimilarity to it can have.
+// Channels that match add at most their weight divided by blockDistanceSaturation to the distance. All other channels add at most their weight, which they also take away from the similarity.
+func getSearchRadius(vector blockVector, minimalSimilarity float64) float64 {
+	radius := 0.0
+	totalWeight := 0.0
+	for _, tolerance := range vector.getTolerances() {
+		radius += tolerance.Weight / blockDistanceSaturation
+		totalWeight += tolerance.Weight
+	}
+
+	return radius + math.Max(0, totalWeight-minimalSimilarity) + blockDistanceEpsilon
+}
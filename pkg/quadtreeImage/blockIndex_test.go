@@ -0,0 +1,217 @@
+package quadtreeImage
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"math/rand"
+	"testing"
+
+	"github.com/xaverhimmelsbach/quadtree-block-compression/pkg/config"
+	"github.com/xaverhimmelsbach/quadtree-block-compression/pkg/utils"
+)
+
+// generateNearDuplicateImage creates an image of a gradient with a noisy pattern that is repeated with a little noise of its own
+func generateNearDuplicateImage(size int) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, size, size))
+	random := rand.New(rand.NewSource(1))
+
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			img.SetRGBA(x, y, color.RGBA{R: uint8(x * 255 / size), G: uint8(y * 255 / size), B: 96, A: 255})
+		}
+	}
+
+	const patternSize = 16
+	pattern := make([]color.RGBA, patternSize*patternSize)
+	for i := range pattern {
+		pattern[i] = color.RGBA{R: uint8(random.Intn(200)), G: uint8(random.Intn(200)), B: uint8(random.Intn(200)), A: 255}
+	}
+
+	for originY := 0; originY < size; originY += 2 * patternSize {
+		for originX := 0; originX < size; originX += 2 * patternSize {
+			for i, c := range pattern {
+				// Vary a tenth of the pixels of each copy by up to 6 levels
+				if random.Intn(10) == 0 {
+					c.R += uint8(random.Intn(7))
+					c.G += uint8(random.Intn(7))
+					c.B += uint8(random.Intn(7))
+				}
+				img.SetRGBA(originX+i%patternSize, originY+i/patternSize, c)
+			}
+		}
+	}
+
+	return img
+}
+
+func TestBlockIndexFindsMostSimilarBlock(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+
+	for _, gray := range []bool{false, true} {
+		// Every other block of the clusters of near-duplicates is stored, so the others have similar but rarely identical blocks in the index
+		blocks := generateBlocks(random, 400, gray)
+		stored := make([]image.Image, 0, len(blocks)/2)
+		queries := make([]image.Image, 0, len(blocks)/2)
+		for i := range blocks {
+			if i%2 == 0 {
+				stored = append(stored, blocks[i])
+			} else {
+				queries = append(queries, blocks[i])
+			}
+		}
+
+		index := newBlockIndex()
+		for i := range stored {
+			index.add(&stored[i])
+		}
+
+		for _, minimalSimilarity := range []float64{0.5, 0.8, 0.9, 0.95} {
+			for _, query := range queries {
+				// Compare with every stored block
+				bestSimilarity := 0.0
+				for _, block := range stored {
+					similarity, err := utils.ComparePixelsWeighted(query, block, query.Bounds())
+					if err != nil {
+						t.Fatal(err)
+					}
+					if similarity > bestSimilarity {
+						bestSimilarity = similarity
+					}
+				}
+
+				match, similarity := index.findSimilar(query, minimalSimilarity)
+				if bestSimilarity < minimalSimilarity {
+					if match != nil {
+						t.Fatalf("gray %t: found a block although none has a similarity of %v", gray, minimalSimilarity)
+					}
+					continue
+				}
+
+				if match == nil {
+					t.Fatalf("gray %t: found no block although one has a similarity of %v of at least %v", gray, bestSimilarity, minimalSimilarity)
+				}
+				if similarity != bestSimilarity {
+					t.Fatalf("gray %t: found a block with a similarity of %v, but the most similar one has %v", gray, similarity, bestSimilarity)
+				}
+			}
+		}
+	}
+}
+
+func TestDeduplicationFindsNearDuplicates(t *testing.T) {
+	cfg := config.Default()
+	cfg.Quadtree.SimilarityCutoff = 0.9
+	cfg.Encoding.DeduplicateBlocks.Enable = true
+
+	// Comparing blocks with the blocks of the same or an adjacent bucket of quantised mean colors deduplicated 121 leaves of this image
+	const boundedSearchReferences = 121
+
+	references := countReferences(t, encodeArchive(t, generateNearDuplicateImage(256), cfg))
+	if references < boundedSearchReferences {
+		t.Errorf("%d leaves were deduplicated, want at least %d", references, boundedSearchReferences)
+	}
+}
+
+func TestBlockIndexFindsTransformedBlocks(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	const minimalSimilarity = 0.9
+
+	// Find two near-duplicates that aren't exact duplicates
+	blocks := generateBlocks(random, 64, false)
+	var stored, nearDuplicate image.Image
+	for i := 0; i+1 < len(blocks) && stored == nil; i += 2 {
+		similarity, err := utils.ComparePixelsWeighted(blocks[i], blocks[i+1], blocks[i].Bounds())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if similarity >= minimalSimilarity && similarity < 0.99 {
+			stored, nearDuplicate = blocks[i], blocks[i+1]
+		}
+	}
+	if stored == nil {
+		t.Fatal("no near-duplicates were generated")
+	}
+
+	index := newBlockIndex()
+	index.add(&stored)
+
+	for orientation := 2; orientation <= 8; orientation++ {
+		query := utils.ConvertImage(utils.ApplyOrientation(nearDuplicate, orientation), utils.ColorModelRGBA)
+		if match, _ := index.findSimilar(query, minimalSimilarity); match != nil {
+			t.Fatalf("orientation %d: reoriented block was found without transforms", orientation)
+		}
+
+		match, transform := index.findTransformed(query, minimalSimilarity)
+		if match == nil {
+			t.Fatalf("orientation %d: reoriented near-duplicate wasn't found", orientation)
+		}
+		similarity, err := utils.ComparePixelsWeighted(query, transform.apply(*match), query.Bounds())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if similarity < minimalSimilarity {
+			t.Errorf("orientation %d: transform %+v turns the found block into one with a similarity of only %v", orientation, transform, similarity)
+		}
+	}
+
+	// Brightened blocks are only found if they match exactly
+	dark := image.NewRGBA(image.Rect(0, 0, BlockSize, BlockSize))
+	for i := range dark.Pix {
+		dark.Pix[i] = uint8(20 + random.Intn(180))
+		if i%4 == 3 {
+			dark.Pix[i] = 0xff
+		}
+	}
+	var darkBlock image.Image = dark
+	index.add(&darkBlock)
+
+	query := utils.ConvertImage(utils.ApplyOrientation(blockTransform{orientation: 1, offset: 30}.apply(dark), 6), utils.ColorModelRGBA)
+	match, transform := index.findTransformed(query, minimalSimilarity)
+	if match != &darkBlock || transform.offset != 30 {
+		t.Fatalf("brightened and rotated block was found as %+v", transform)
+	}
+	if transformed := transform.apply(*match).(*image.RGBA); string(transformed.Pix) != string(query.(*image.RGBA).Pix) {
+		t.Error("transform doesn't turn the found block into the brightened and rotated block")
+	}
+}
+
+func TestTransformsOnlyReplaceBlocksOfLeaves(t *testing.T) {
+	cfg := config.Default()
+	cfg.Quadtree.SimilarityCutoff = 0.95
+	cfg.Encoding.DeduplicateBlocks.Enable = true
+	cfg.Encoding.DeduplicateBlocks.Transforms = true
+
+	qti, err := NewQuadtreeImage(generateRepetitiveImage(200, 150), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = qti.Partition(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	transformed := 0
+	var walk func(node *QuadtreeElement)
+	walk = func(node *QuadtreeElement) {
+		if node.blockTransform.isIdentity() {
+			for _, child := range node.children {
+				walk(child)
+			}
+			return
+		}
+
+		if !node.isLeaf {
+			t.Errorf("node %q was partitioned but has a transformed block", node.id)
+		}
+		if node.baseImage.Bounds().Dx() > BlockSize && node.compareImages() <= cfg.Quadtree.SimilarityCutoff {
+			t.Errorf("leaf %q doesn't meet the similarity cutoff with its transformed block", node.id)
+		}
+		transformed++
+	}
+	walk(qti.root)
+
+	if transformed == 0 {
+		t.Error("no blocks were deduplicated with a transform")
+	}
+}
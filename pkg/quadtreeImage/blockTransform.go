@@ -0,0 +1,65 @@
+package quadtreeImage
+
+import (
+	"image"
+	"image/color"
+
+	"github.com/xaverhimmelsbach/quadtree-block-compression/pkg/utils"
+)
+
+// blockTransform describes how the block of a referenced leaf is changed to stand in for the block of the referencing leaf
+type blockTransform struct {
+	// EXIF orientation (1 to 8) the block is flipped and rotated with, 1 keeps it as it is
+	orientation int
+	// Value added to every color channel of the block after reorienting it, in 8-bit steps
+	offset int
+}
+
+// identityTransform keeps blocks as they are
+var identityTransform = blockTransform{orientation: 1}
+
+// isIdentity returns whether t keeps blocks as they are
+func (t blockTransform) isIdentity() bool {
+	return t.orientation <= 1 && t.offset == 0
+}
+
+// apply returns block reoriented and offset according to t, in the color model of block
+func (t blockTransform) apply(block image.Image) image.Image {
+	if t.isIdentity() {
+		return block
+	}
+
+	transformedImage := utils.ConvertImage(utils.ApplyOrientation(block, t.orientation), utils.GetColorModel(block))
+	if t.offset == 0 {
+		return transformedImage
+	}
+
+	// Offset the color channels and keep the alpha channel
+	offset := int32(t.offset) * 0x101
+	bounds := transformedImage.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			r, g, b, a := transformedImage.At(x, y).RGBA()
+			transformedImage.Set(x, y, color.RGBA64{
+				R: offsetChannel(r, a, offset),
+				G: offsetChannel(g, a, offset),
+				B: offsetChannel(b, a, offset),
+				A: uint16(a),
+			})
+		}
+	}
+
+	return transformedImage
+}
+
+// offsetChannel adds offset to the alpha-premultiplied channel value, keeping it within the range allowed by alpha
+func offsetChannel(channel uint32, alpha uint32, offset int32) uint16 {
+	value := int32(channel) + offset
+	if value < 0 {
+		return 0
+	}
+	if value > int32(alpha) {
+		return uint16(alpha)
+	}
+	return uint16(value)
+}
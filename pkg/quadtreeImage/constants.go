@@ -4,4 +4,16 @@ const (
 	BlockSize  = 8
 	ChildCount = 4
 	MetaFile   = "meta"
+	// Files holding the metadata blocks of the original image file
+	ExifFile       = "exif"
+	ICCProfileFile = "icc"
+	XMPFile        = "xmp"
+	// Block formats, BlockFormatAuto encodes blocks lossy where possible, BlockFormatPNG always losslessly
+	BlockFormatAuto = "auto"
+	BlockFormatPNG  = "png"
 )
+
+// isReservedFile returns whether the archive file name holds information other than quadtree leaves
+func isReservedFile(name string) bool {
+	return name == MetaFile || name == ExifFile || name == ICCProfileFile || name == XMPFile
+}
@@ -0,0 +1,152 @@
+package quadtreeImage
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+
+	"github.com/xaverhimmelsbach/quadtree-block-compression/pkg/config"
+)
+
+// deblock smooths the seams between neighbouring leaves of the decoded image img
+func (q *QuadtreeImage) deblock(img draw.Image) {
+	visualizations := q.root.visualize()
+
+	leafBounds := make([]image.Rectangle, 0, len(visualizations))
+	for _, visualization := range visualizations {
+		leafBounds = append(leafBounds, visualization.image.Bounds())
+	}
+
+	deblockLeaves(img, leafBounds, q.config.Decoding.Deblocking)
+}
+
+// deblockLeaves smooths the seams between the leaves with the bounds leafBounds in the decoded image img.
+// The smoothed area grows with the size of the leaves on both sides of a seam and the correction grows with the discontinuity across it.
+func deblockLeaves(img draw.Image, leafBounds []image.Rectangle, deblockingConfig config.DeblockingConfig) {
+	strength := deblockingConfig.Strength
+	threshold := deblockingConfig.Threshold
+	bounds := img.Bounds()
+
+	// Record the edge length of the leaf covering each pixel
+	leafSizes := make([]int, bounds.Dx()*bounds.Dy())
+	leafSizeAt := func(x int, y int) int {
+		return leafSizes[(y-bounds.Min.Y)*bounds.Dx()+x-bounds.Min.X]
+	}
+
+	for _, leaf := range leafBounds {
+		visibleBounds := leaf.Intersect(bounds)
+		for y := visibleBounds.Min.Y; y < visibleBounds.Max.Y; y++ {
+			for x := visibleBounds.Min.X; x < visibleBounds.Max.X; x++ {
+				leafSizes[(y-bounds.Min.Y)*bounds.Dx()+x-bounds.Min.X] = leaf.Dx()
+			}
+		}
+	}
+
+	// Smooth the left seam of every leaf
+	for _, leaf := range leafBounds {
+		visibleBounds := leaf.Intersect(bounds)
+		x := leaf.Min.X
+
+		if visibleBounds.Empty() || x <= bounds.Min.X {
+			continue
+		}
+
+		for y := visibleBounds.Min.Y; y < visibleBounds.Max.Y; y++ {
+			radius := getSeamRadius(leafSizeAt(x-1, y), leaf.Dx())
+			smoothSeam(img, image.Pt(x, y), image.Pt(1, 0), radius, strength, threshold)
+		}
+	}
+
+	// Smooth the upper seam of every leaf
+	for _, leaf := range leafBounds {
+		visibleBounds := leaf.Intersect(bounds)
+		y := leaf.Min.Y
+
+		if visibleBounds.Empty() || y <= bounds.Min.Y {
+			continue
+		}
+
+		for x := visibleBounds.Min.X; x < visibleBounds.Max.X; x++ {
+			radius := getSeamRadius(leafSizeAt(x, y-1), leaf.Dy())
+			smoothSeam(img, image.Pt(x, y), image.Pt(0, 1), radius, strength, threshold)
+		}
+	}
+}
+
+// getSeamRadius returns how many pixels on each side of a seam between two leaves of the given sizes should be smoothed.
+// Each stored block pixel covers size/BlockSize pixels of a leaf, half of the smaller one is smoothed.
+// Leaves of size BlockSize are stored in full resolution and thus have no seams.
+func getSeamRadius(sizeA int, sizeB int) int {
+	smallerSize := sizeA
+	if sizeB < smallerSize {
+		smallerSize = sizeB
+	}
+
+	return smallerSize / BlockSize / 2
+}
+
+// smoothSeam blends the pixels around seam along direction step into a linear ramp.
+// seam is the first pixel behind the seam, step points across the seam.
+// The seam is left untouched if any channel differs by more than threshold (in 8-bit range), as it is most likely an edge of the image content.
+func smoothSeam(img draw.Image, seam image.Point, step image.Point, radius int, strength float64, threshold float64) {
+	if radius <= 0 {
+		return
+	}
+
+	// Compute discontinuity per channel in 16-bit depth to support every color model
+	before := getChannels(img, seam.X-step.X, seam.Y-step.Y)
+	after := getChannels(img, seam.X, seam.Y)
+
+	var deltas [4]float64
+	for c := range deltas {
+		deltas[c] = after[c] - before[c]
+		if math.Abs(deltas[c]) > threshold*0x101 {
+			return
+		}
+	}
+
+	for k := 0; k < radius; k++ {
+		beforePoint := image.Pt(seam.X-step.X*(k+1), seam.Y-step.Y*(k+1))
+		afterPoint := image.Pt(seam.X+step.X*k, seam.Y+step.Y*k)
+
+		if !beforePoint.In(img.Bounds()) || !afterPoint.In(img.Bounds()) {
+			return
+		}
+
+		// Pixels right at the seam meet in the middle, the weight falls off linearly from there
+		weight := strength * float64(radius-k) / float64(2*radius)
+
+		before = getChannels(img, beforePoint.X, beforePoint.Y)
+		after = getChannels(img, afterPoint.X, afterPoint.Y)
+
+		for c, delta := range deltas {
+			before[c] += delta * weight
+			after[c] -= delta * weight
+		}
+
+		setChannels(img, beforePoint.X, beforePoint.Y, before)
+		setChannels(img, afterPoint.X, afterPoint.Y, after)
+	}
+}
+
+// getChannels returns the 16-bit premultiplied channels of the pixel of img at x, y
+func getChannels(img image.Image, x int, y int) [4]float64 {
+	r, g, b, a := img.At(x, y).RGBA()
+	return [4]float64{float64(r), float64(g), float64(b), float64(a)}
+}
+
+// setChannels sets the pixel of img at x, y to the 16-bit premultiplied channels, clamping them to their valid range
+func setChannels(img draw.Image, x int, y int, channels [4]float64) {
+	img.Set(x, y, color.RGBA64{
+		R: clampChannel(channels[0]),
+		G: clampChannel(channels[1]),
+		B: clampChannel(channels[2]),
+		A: clampChannel(channels[3]),
+	})
+}
+
+// clampChannel rounds value and clamps it to the range of a 16-bit color channel
+func clampChannel(value float64) uint16 {
+	return uint16(math.Max(0, math.Min(0xffff, math.Round(value))))
+}
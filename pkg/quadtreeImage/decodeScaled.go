@@ -0,0 +1,241 @@
+package quadtreeImage
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"io"
+	"math"
+	"sort"
+
+	"github.com/xaverhimmelsbach/quadtree-block-compression/pkg/config"
+	"github.com/xaverhimmelsbach/quadtree-block-compression/pkg/utils"
+)
+
+// DecodeScaled decodes an encoded quadtree image read from reader directly at a reduced resolution.
+// targetSize is the length of the longer side of the returned image and can't exceed the one of the original image.
+// Leaves are painted at their scaled size, subtrees whose leaves would be smaller than one output pixel are painted in the average color of their leaves.
+func DecodeScaled(reader io.Reader, targetSize int, cfg *config.Config) (image.Image, error) {
+	if targetSize <= 0 {
+		return nil, fmt.Errorf("target size has to be greater than 0, got %d", targetSize)
+	}
+
+	archiveReader, err := NewArchiveReader(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	// Parse metadata
+	meta, err := readMetadata(archiveReader)
+	if err != nil {
+		return nil, err
+	}
+
+	if longerSideLength := getLongerSideLength(meta); targetSize > longerSideLength {
+		return nil, fmt.Errorf("target size %d is larger than the longer side of the image (%d)", targetSize, longerSideLength)
+	}
+
+	space, err := getColorSpace(meta.colorSpace)
+	if err != nil {
+		return nil, err
+	}
+
+	leaves := newLeafReader(archiveReader, meta)
+	if !space.isPlanar() {
+		return decodeScaledTree(leaves, "", meta, targetSize, cfg)
+	}
+
+	// Decode every plane at its own reduced resolution
+	planeImages := make([]image.Image, 0, len(space.planes))
+	for _, p := range space.planes {
+		planeBounds := p.getPlaneBounds(image.Rect(0, 0, meta.width, meta.height))
+		planeMeta := &metadata{
+			treeHeight: getTreeHeight(getPaddedSideLength(planeBounds)),
+			width:      planeBounds.Dx(),
+			height:     planeBounds.Dy(),
+			colorModel: utils.ColorModelGray,
+			colorSpace: ColorSpaceRGB,
+		}
+
+		planeTargetSize := targetSize / p.subsampling
+		if planeTargetSize < 1 {
+			planeTargetSize = 1
+		}
+
+		planeImage, err := decodeScaledTree(leaves, p.name, planeMeta, planeTargetSize, cfg)
+		if err != nil {
+			return nil, err
+		}
+		planeImages = append(planeImages, planeImage)
+	}
+
+	// Bring subsampled planes to the resolution of the first plane and merge them
+	upsamplingInterpolator, err := getInterpolator(cfg.Quadtree.UpsamplingInterpolator)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range planeImages {
+		if !planeImages[i].Bounds().Eq(planeImages[0].Bounds()) {
+			planeImages[i] = utils.Scale(planeImages[i], planeImages[0].Bounds(), upsamplingInterpolator)
+		}
+	}
+
+	return space.merge(planeImages), nil
+}
+
+// decodeScaledTree renders the quadtree stored in the directory pathPrefix of the archive of leaves at a reduced resolution.
+// targetSize is the length of the longer side of the returned image.
+func decodeScaledTree(leaves *leafReader, pathPrefix string, meta *metadata, targetSize int, cfg *config.Config) (image.Image, error) {
+	downsamplingInterpolator, err := getInterpolator(cfg.Quadtree.DownsamplingInterpolator)
+	if err != nil {
+		return nil, err
+	}
+	upsamplingInterpolator, err := getInterpolator(cfg.Quadtree.UpsamplingInterpolator)
+	if err != nil {
+		return nil, err
+	}
+
+	// Scale the padded square so that the longer side of the original image covers at least targetSize pixels
+	paddedSideLength := BlockSize << meta.treeHeight
+	longerSideLength := getLongerSideLength(meta)
+	scaledSideLength := (paddedSideLength*targetSize + longerSideLength - 1) / longerSideLength
+	scaleBounds := func(bounds image.Rectangle) image.Rectangle {
+		return image.Rect(
+			bounds.Min.X*scaledSideLength/paddedSideLength,
+			bounds.Min.Y*scaledSideLength/paddedSideLength,
+			bounds.Max.X*scaledSideLength/paddedSideLength,
+			bounds.Max.Y*scaledSideLength/paddedSideLength,
+		)
+	}
+
+	// Nodes below maxDepth are smaller than one output pixel
+	maxDepth := 0
+	for scaledSideLength>>(maxDepth+1) > 0 && maxDepth < meta.treeHeight {
+		maxDepth++
+	}
+
+	scaledImage := utils.NewImage(meta.colorModel, image.Rect(0, 0, scaledSideLength, scaledSideLength))
+
+	// Iterate over leaves in a stable order so that the average colors of subtrees are summed up the same way on every run
+	filenames := make([]string, 0, len(leaves.archiveReader.Files()))
+	for filename := range leaves.archiveReader.Files() {
+		if _, ok := trimPath(pathPrefix, filename); ok && !isReservedFile(filename) {
+			filenames = append(filenames, filename)
+		}
+	}
+	sort.Strings(filenames)
+
+	// Colors of the leaves below maxDepth, summed up per node at maxDepth by its bounds inside of the padded image
+	subtreeColors := make(map[image.Rectangle]*colorSum)
+
+	for _, filename := range filenames {
+		treePath, _ := trimPath(pathPrefix, filename)
+		childIndices, err := parseTreePath(treePath, meta.treeHeight)
+		if err != nil {
+			return nil, err
+		}
+
+		// Follow the path to get the bounds of the leaf and of the node at maxDepth containing it inside of the padded image
+		bounds := image.Rect(0, 0, paddedSideLength, paddedSideLength)
+		subtreeBounds := bounds
+		for depth, childIndex := range childIndices {
+			bounds = getChildBounds(bounds, childIndex)
+			if depth < maxDepth {
+				subtreeBounds = bounds
+			}
+		}
+
+		fileContents, err := leaves.archiveReader.Open(filename)
+		if err != nil {
+			return nil, err
+		}
+
+		blockImage, err := leaves.readLeafImage(*fileContents, meta.colorModel)
+		if err != nil {
+			return nil, err
+		}
+
+		// Leaves below maxDepth are smaller than one output pixel, so their subtree is painted in the average color of its leaves
+		if len(childIndices) > maxDepth {
+			sum, ok := subtreeColors[subtreeBounds]
+			if !ok {
+				sum = new(colorSum)
+				subtreeColors[subtreeBounds] = sum
+			}
+			sum.add(blockImage, bounds.Dx()*bounds.Dy())
+			continue
+		}
+
+		scaledBounds := scaleBounds(bounds)
+
+		// Leaves smaller than their stored block need to be downsampled
+		interpolator := upsamplingInterpolator
+		if scaledBounds.Dx() < blockImage.Bounds().Dx() {
+			interpolator = downsamplingInterpolator
+		}
+
+		scaledBlockImage := utils.Scale(blockImage, scaledBounds, interpolator)
+		draw.Draw(scaledImage, scaledBounds, scaledBlockImage, scaledBounds.Min, draw.Src)
+	}
+
+	for subtreeBounds, sum := range subtreeColors {
+		draw.Draw(scaledImage, scaleBounds(subtreeBounds), image.NewUniform(sum.average()), image.Point{}, draw.Src)
+	}
+
+	// Cut off the padding, keeping at least one pixel of the shorter side
+	outputBounds := image.Rect(0, 0, meta.width*targetSize/longerSideLength, meta.height*targetSize/longerSideLength)
+	if outputBounds.Dx() == 0 {
+		outputBounds.Max.X = 1
+	}
+	if outputBounds.Dy() == 0 {
+		outputBounds.Max.Y = 1
+	}
+	outputImage := utils.NewImage(meta.colorModel, outputBounds)
+	draw.Draw(outputImage, outputImage.Bounds(), scaledImage, image.Point{}, draw.Src)
+
+	return outputImage, nil
+}
+
+// getLongerSideLength returns the length of the longer side of the original image
+func getLongerSideLength(meta *metadata) int {
+	if meta.height > meta.width {
+		return meta.height
+	}
+	return meta.width
+}
+
+// colorSum adds up the alpha-premultiplied colors of images, weighted by the area they cover
+type colorSum struct {
+	r, g, b, a float64
+	// Sum of the weights of all added images
+	area float64
+}
+
+// add adds the mean color of img to the sum, weighted by area
+func (c *colorSum) add(img image.Image, area int) {
+	bounds := img.Bounds()
+	weight := float64(area) / float64(bounds.Dx()*bounds.Dy())
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			r, g, b, a := img.At(x, y).RGBA()
+			c.r += float64(r) * weight
+			c.g += float64(g) * weight
+			c.b += float64(b) * weight
+			c.a += float64(a) * weight
+		}
+	}
+	c.area += float64(area)
+}
+
+// average returns the weighted mean of all added colors
+func (c *colorSum) average() color.Color {
+	return color.RGBA64{
+		R: uint16(math.Round(c.r / c.area)),
+		G: uint16(math.Round(c.g / c.area)),
+		B: uint16(math.Round(c.b / c.area)),
+		A: uint16(math.Round(c.a / c.area)),
+	}
+}
@@ -0,0 +1,134 @@
+package quadtreeImage
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"os"
+	"testing"
+
+	"github.com/xaverhimmelsbach/quadtree-block-compression/pkg/config"
+	"github.com/xaverhimmelsbach/quadtree-block-compression/pkg/utils"
+)
+
+// averageDownscale scales img down to bounds by painting every pixel in the mean color of the pixels of img it covers
+func averageDownscale(img image.Image, bounds image.Rectangle) image.Image {
+	source := img.Bounds()
+	scaled := image.NewRGBA64(bounds)
+	for y := 0; y < bounds.Dy(); y++ {
+		for x := 0; x < bounds.Dx(); x++ {
+			covered := image.Rect(
+				source.Min.X+x*source.Dx()/bounds.Dx(),
+				source.Min.Y+y*source.Dy()/bounds.Dy(),
+				source.Min.X+(x+1)*source.Dx()/bounds.Dx(),
+				source.Min.Y+(y+1)*source.Dy()/bounds.Dy(),
+			)
+
+			sum := new(colorSum)
+			sum.add(utils.SubImage(img, covered), covered.Dx()*covered.Dy())
+			scaled.Set(bounds.Min.X+x, bounds.Min.Y+y, sum.average())
+		}
+	}
+
+	return scaled
+}
+
+// getMeanDifference returns the mean absolute difference of the 8-bit color channels of two images of the same bounds
+func getMeanDifference(a image.Image, b image.Image) float64 {
+	bounds := a.Bounds()
+	sum := 0.0
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			rA, gA, bA, aA := a.At(x, y).RGBA()
+			rB, gB, bB, aB := b.At(x, y).RGBA()
+			for _, channels := range [][2]uint32{{rA, rB}, {gA, gB}, {bA, bB}, {aA, aB}} {
+				sum += math.Abs(float64(channels[0]>>8) - float64(channels[1]>>8))
+			}
+		}
+	}
+
+	return sum / float64(4*bounds.Dx()*bounds.Dy())
+}
+
+// generateCheckerboardImage creates an image of black and white squares of the size of a minimal block
+func generateCheckerboardImage(width int, height int) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			if (x/BlockSize+y/BlockSize)%2 == 0 {
+				img.Set(x, y, color.White)
+			} else {
+				img.Set(x, y, color.Black)
+			}
+		}
+	}
+
+	return img
+}
+
+func TestDecodeScaledMatchesDownscaledDecoding(t *testing.T) {
+	images := []struct {
+		name        string
+		img         image.Image
+		targetSizes []int
+		// Largest mean difference of a channel from the downscaled decoded image
+		tolerance float64
+	}{
+		// Subtrees of the squares are cut off at target sizes below 32 and cover whole output pixels, so averaging them matches downscaling
+		{name: "checkerboard", img: generateCheckerboardImage(256, 192), targetSizes: []int{4, 8, 16, 32, 64, 256}, tolerance: 1},
+		// The noisy pattern consists of minimal blocks that are smaller than one output pixel at all but the largest target sizes
+		{name: "pattern", img: generateRepetitiveImage(200, 150), targetSizes: []int{25, 50, 100, 200}, tolerance: 5},
+	}
+
+	colorSpaces := []struct {
+		name      string
+		configure func(cfg *config.Config)
+	}{
+		{name: "RGB", configure: func(cfg *config.Config) {}},
+		{name: "YCbCr420", configure: func(cfg *config.Config) { cfg.Quadtree.ColorSpace = ColorSpaceYCbCr420 }},
+	}
+
+	for _, test := range images {
+		bounds := test.img.Bounds()
+
+		for _, space := range colorSpaces {
+			cfg := config.Default()
+			cfg.Quadtree.DownsamplingInterpolator = "BiLinear"
+			space.configure(cfg)
+
+			path := encodeArchive(t, test.img, cfg)
+			decoded, err := png.Decode(bytes.NewReader(decodeArchive(t, path, cfg)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			archive, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, targetSize := range test.targetSizes {
+				scaled, err := DecodeScaled(bytes.NewReader(archive), targetSize, cfg)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				wantBounds := image.Rect(0, 0, targetSize, bounds.Dy()*targetSize/bounds.Dx())
+				if scaled.Bounds() != wantBounds {
+					t.Fatalf("%s %s %d: scaled image has the bounds %v, want %v", test.name, space.name, targetSize, scaled.Bounds(), wantBounds)
+				}
+
+				if difference := getMeanDifference(scaled, averageDownscale(decoded, wantBounds)); difference > test.tolerance {
+					t.Errorf("%s %s %d: scaled image differs from the downscaled decoded image by %.2f on average, want at most %v", test.name, space.name, targetSize, difference, test.tolerance)
+				}
+			}
+
+			// Scaled decoding only reduces the resolution
+			_, err = DecodeScaled(bytes.NewReader(archive), bounds.Dx()+1, cfg)
+			if err == nil {
+				t.Errorf("%s %s: target size larger than the image was accepted", test.name, space.name)
+			}
+		}
+	}
+}
@@ -0,0 +1,412 @@
+package quadtreeImage
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"image/draw"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"runtime"
+	"sort"
+	"sync"
+
+	"github.com/xaverhimmelsbach/quadtree-block-compression/pkg/config"
+	"github.com/xaverhimmelsbach/quadtree-block-compression/pkg/utils"
+)
+
+// streamedTree is a quadtree whose leaves are painted straight into its canvas while the archive is read
+type streamedTree struct {
+	storedTree
+	// Color model of the block images of the quadtree
+	colorModel utils.ColorModel
+	// Decoded image without padding
+	canvas draw.Image
+	// Leaves painted into canvas, kept for deblocking
+	leaves []streamedLeaf
+}
+
+// streamedLeaf is a leaf of a streamedTree
+type streamedLeaf struct {
+	// Path of the leaf inside of its quadtree
+	treePath string
+	// Bounds of the leaf inside of the padded image
+	bounds image.Rectangle
+}
+
+// streamedBlock is a leaf file read from the archive that waits to be decoded and painted
+type streamedBlock struct {
+	tree     *streamedTree
+	leaf     streamedLeaf
+	filename string
+	contents []byte
+}
+
+// streamedReference is a leaf stored as reference, it is painted once all block images have been read
+type streamedReference struct {
+	tree *streamedTree
+	leaf streamedLeaf
+	resolvedReference
+}
+
+// resolvedReference is a reference whose chain has been followed to the leaf holding its block image
+type resolvedReference struct {
+	// Filename of the leaf holding the block image
+	target string
+	// Transforms turning the block image into the block of the reference, the last one is applied first
+	transforms []blockTransform
+}
+
+// streamingDecoder paints the leaves of an archive into the canvases of its quadtrees
+type streamingDecoder struct {
+	trees []*streamedTree
+	// Filenames of all leaves of the archive
+	leafFilenames map[string]bool
+	// Contents of the leaf files that may be references, which can only be told apart once the metadata has been read
+	referenceCandidates map[string][]byte
+	// References of the archive by filename
+	references map[string]blockReference
+	// Resolved references of the archive by filename
+	resolvedReferences map[string]resolvedReference
+	// Filenames of all leaves that hold the block image of a reference
+	referenced map[string]bool
+	// Decoded block images of referenced leaves by filename
+	referencedBlocks map[string]image.Image
+	// Guards referencedBlocks and the leaves of all trees
+	mutex    sync.Mutex
+	config   *config.Config
+	progress *progressTracker
+}
+
+// decodeStreaming decodes the quadtree file at quadtreePath without building its quadtree or keeping the archive in memory.
+// The archive is read twice: first for its metadata and references, then every leaf is decoded and painted into the output image as soon as it is read.
+// Only block images that are the target of a reference are kept until the end, so memory stays close to the size of the output image.
+func decodeStreaming(ctx context.Context, quadtreePath string, outputPath string, cfg *config.Config) (io.Reader, error) {
+	decoder := &streamingDecoder{
+		leafFilenames:       make(map[string]bool),
+		referenceCandidates: make(map[string][]byte),
+		references:          make(map[string]blockReference),
+		resolvedReferences:  make(map[string]resolvedReference),
+		referenced:          make(map[string]bool),
+		referencedBlocks:    make(map[string]image.Image),
+		config:              cfg,
+	}
+
+	// Read reserved files and references, the reserved files are written after the leaves they describe
+	reservedFiles, err := decoder.scan(quadtreePath)
+	if err != nil {
+		return nil, err
+	}
+
+	meta, err := readMetadata(reservedFiles)
+	if err != nil {
+		return nil, err
+	}
+
+	// Validate all references before decoding any block images
+	err = decoder.resolveReferences(meta)
+	if err != nil {
+		return nil, err
+	}
+
+	space, err := getColorSpace(meta.colorSpace)
+	if err != nil {
+		return nil, err
+	}
+
+	storedTrees, err := getStoredTrees(meta)
+	if err != nil {
+		return nil, err
+	}
+
+	// Planes are stored as grayscale images
+	for _, tree := range storedTrees {
+		colorModel := meta.colorModel
+		if space.isPlanar() {
+			colorModel = utils.ColorModelGray
+		}
+
+		decoder.trees = append(decoder.trees, &streamedTree{
+			storedTree: tree,
+			colorModel: colorModel,
+			canvas:     utils.NewImage(colorModel, image.Rect(0, 0, tree.bounds.Dx(), tree.bounds.Dy())),
+		})
+	}
+
+	decoder.progress = newProgressTracker(ctx, StageDecode, len(decoder.leafFilenames))
+
+	err = decoder.paint(ctx, quadtreePath)
+	if err != nil {
+		return nil, err
+	}
+
+	// Apply decoding filters to every quadtree on its own
+	planeImages := make([]image.Image, 0, len(decoder.trees))
+	for _, tree := range decoder.trees {
+		if cfg.Decoding.Deblocking.Enable {
+			tree.deblock(cfg.Decoding.Deblocking)
+		}
+		planeImages = append(planeImages, tree.canvas)
+	}
+
+	decodedImage := planeImages[0]
+	if space.isPlanar() {
+		decodedImage, err = mergePlaneImages(planeImages, image.Rect(0, 0, meta.width, meta.height), meta.colorSpace, cfg)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return writeDecodedImage(decodedImage, outputPath, readImageMetadata(reservedFiles), cfg)
+}
+
+// scan reads the archive at quadtreePath once to collect its reserved files, the names of its leaves and the contents of leaves that may be references.
+// The reserved files are returned as an ArchiveReader holding nothing else.
+func (d *streamingDecoder) scan(quadtreePath string) (*ArchiveReader, error) {
+	reservedFiles := &ArchiveReader{fileCache: make(map[string]*[]byte)}
+
+	err := walkArchive(quadtreePath, func(filename string, contents io.Reader) error {
+		fileContents, err := ioutil.ReadAll(contents)
+		if err != nil {
+			return err
+		}
+
+		if isReservedFile(filename) {
+			reservedFiles.fileCache[filename] = &fileContents
+			return nil
+		}
+		d.leafFilenames[filename] = true
+
+		// Pseudo symlinks of older archives are files without a known file type
+		isLegacyCandidate, err := isLegacyReference(fileContents)
+		if err != nil {
+			return err
+		}
+
+		if isBlockReference(fileContents) || isLegacyCandidate {
+			d.referenceCandidates[filename] = fileContents
+		}
+
+		return nil
+	})
+
+	return reservedFiles, err
+}
+
+// resolveReferences parses the references among the reference candidates according to meta and follows every one of them to the leaf holding its block image
+func (d *streamingDecoder) resolveReferences(meta *metadata) error {
+	for filename, fileContents := range d.referenceCandidates {
+		reference, isReference, err := parseLeafFile(fileContents, !meta.hasReferenceRecords)
+		if err != nil {
+			return fmt.Errorf("leaf %s: %w", filename, err)
+		}
+
+		if isReference {
+			d.references[filename] = reference
+		}
+	}
+	d.referenceCandidates = nil
+
+	lookup := func(filename string) (blockReference, bool, error) {
+		if reference, ok := d.references[filename]; ok {
+			return reference, true, nil
+		}
+		if !d.leafFilenames[filename] {
+			return blockReference{}, false, fs.ErrNotExist
+		}
+		return blockReference{}, false, nil
+	}
+
+	for filename, reference := range d.references {
+		target, transforms, err := resolveReference(reference, lookup)
+		if err != nil {
+			return fmt.Errorf("leaf %s: %w", filename, err)
+		}
+
+		d.resolvedReferences[filename] = resolvedReference{target: target, transforms: transforms}
+		d.referenced[target] = true
+	}
+
+	return nil
+}
+
+// paint reads the archive at quadtreePath a second time and paints every leaf into the canvas of its quadtree.
+// Block images are decoded by a pool of workers while the archive is read, references are painted at the end.
+func (d *streamingDecoder) paint(ctx context.Context, quadtreePath string) error {
+	workerCount := 1
+	if d.config.Decoding.Parallelism {
+		workerCount = runtime.GOMAXPROCS(0)
+	}
+
+	// Bound the number of leaf files held in memory
+	blocks := make(chan streamedBlock, workerCount)
+	var references []streamedReference
+
+	var firstErr error
+	var errMutex sync.Mutex
+	setErr := func(err error) {
+		errMutex.Lock()
+		if firstErr == nil {
+			firstErr = err
+		}
+		errMutex.Unlock()
+	}
+	getErr := func() error {
+		errMutex.Lock()
+		defer errMutex.Unlock()
+		return firstErr
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < workerCount; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for block := range blocks {
+				// Drain the remaining blocks after a failure
+				if getErr() != nil {
+					continue
+				}
+
+				err := d.paintBlock(block)
+				if err != nil {
+					setErr(fmt.Errorf("leaf %s: %w", block.filename, err))
+				}
+			}
+		}()
+	}
+
+	walkErr := walkArchive(quadtreePath, func(filename string, contents io.Reader) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := getErr(); err != nil {
+			return err
+		}
+
+		if isReservedFile(filename) {
+			return nil
+		}
+
+		tree, leaf, err := d.getLeaf(filename)
+		if err != nil {
+			return err
+		}
+		// Skip files that don't belong to any quadtree
+		if tree == nil {
+			return nil
+		}
+
+		if reference, ok := d.resolvedReferences[filename]; ok {
+			references = append(references, streamedReference{tree: tree, leaf: leaf, resolvedReference: reference})
+			return nil
+		}
+
+		fileContents, err := ioutil.ReadAll(contents)
+		if err != nil {
+			return err
+		}
+
+		blocks <- streamedBlock{tree: tree, leaf: leaf, filename: filename, contents: fileContents}
+		return nil
+	})
+
+	close(blocks)
+	wg.Wait()
+
+	if walkErr != nil {
+		return walkErr
+	}
+	if err := getErr(); err != nil {
+		return err
+	}
+
+	// All block images of references have been decoded by now, unless they don't belong to any quadtree
+	for _, reference := range references {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		block, ok := d.referencedBlocks[reference.target]
+		if !ok {
+			return fmt.Errorf("reference to %s: %w", reference.target, fs.ErrNotExist)
+		}
+
+		d.paintLeaf(reference.tree, reference.leaf, applyTransforms(block, reference.transforms))
+	}
+
+	return nil
+}
+
+// getLeaf returns the quadtree a leaf file belongs to and the position of the leaf inside of it.
+// The returned tree is nil if the file doesn't belong to any quadtree.
+func (d *streamingDecoder) getLeaf(filename string) (*streamedTree, streamedLeaf, error) {
+	for _, tree := range d.trees {
+		treePath, ok := trimPath(tree.pathPrefix, filename)
+		if !ok {
+			continue
+		}
+
+		childIndices, err := parseTreePath(treePath, tree.treeHeight)
+		if err != nil {
+			return nil, streamedLeaf{}, err
+		}
+
+		// Follow the path to get the bounds of the leaf inside of the padded image
+		paddedSideLength := BlockSize << tree.treeHeight
+		bounds := image.Rect(0, 0, paddedSideLength, paddedSideLength)
+		for _, childIndex := range childIndices {
+			bounds = getChildBounds(bounds, childIndex)
+		}
+
+		return tree, streamedLeaf{treePath: treePath, bounds: bounds}, nil
+	}
+
+	return nil, streamedLeaf{}, nil
+}
+
+// paintBlock decodes the block image of a leaf file and paints it, keeping it if it holds the block image of a reference
+func (d *streamingDecoder) paintBlock(block streamedBlock) error {
+	blockImage, err := decodeBlock(block.contents, block.tree.colorModel)
+	if err != nil {
+		return err
+	}
+
+	if d.referenced[block.filename] {
+		d.mutex.Lock()
+		d.referencedBlocks[block.filename] = blockImage
+		d.mutex.Unlock()
+	}
+
+	d.paintLeaf(block.tree, block.leaf, blockImage)
+	return nil
+}
+
+// paintLeaf scales blockImage up to the bounds of leaf and draws it into the canvas of tree.
+// Leaves don't overlap, so several leaves can be painted at the same time.
+func (d *streamingDecoder) paintLeaf(tree *streamedTree, leaf streamedLeaf, blockImage image.Image) {
+	// Parts of the leaf that lie in the padding are clipped
+	drawBlock(tree.canvas, leaf.bounds, blockImage, d.config)
+
+	d.mutex.Lock()
+	tree.leaves = append(tree.leaves, leaf)
+	d.mutex.Unlock()
+
+	d.progress.add(getPathDepth(leaf.treePath), 0)
+}
+
+// deblock smooths the seams between the leaves of the canvas in the same order as the quadtree would be traversed
+func (tree *streamedTree) deblock(deblockingConfig config.DeblockingConfig) {
+	// Child indices are single digits, so sorting the paths yields a depth-first traversal
+	sort.Slice(tree.leaves, func(i, j int) bool {
+		return tree.leaves[i].treePath < tree.leaves[j].treePath
+	})
+
+	leafBounds := make([]image.Rectangle, 0, len(tree.leaves))
+	for _, leaf := range tree.leaves {
+		leafBounds = append(leafBounds, leaf.bounds)
+	}
+
+	deblockLeaves(tree.canvas, leafBounds, deblockingConfig)
+}
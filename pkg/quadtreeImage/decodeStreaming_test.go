@@ -0,0 +1,166 @@
+package quadtreeImage
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/xaverhimmelsbach/quadtree-block-compression/pkg/config"
+)
+
+// generateRepetitiveImage creates an image of a gradient with a noisy pattern that is repeated as is, flipped and brightened, so that blocks can be deduplicated with and without transforms
+func generateRepetitiveImage(width int, height int) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	random := rand.New(rand.NewSource(1))
+
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.SetRGBA(x, y, color.RGBA{R: uint8(x * 255 / width), G: uint8(y * 255 / height), B: 96, A: 255})
+		}
+	}
+
+	const patternSize = 32
+	pattern := make([]color.RGBA, patternSize*patternSize)
+	for i := range pattern {
+		pattern[i] = color.RGBA{R: uint8(random.Intn(200)), G: uint8(random.Intn(200)), B: uint8(random.Intn(200)), A: 255}
+	}
+
+	for i, origin := range []image.Point{{0, 0}, {64, 0}, {0, 64}, {64, 64}, {128, 32}} {
+		for y := 0; y < patternSize; y++ {
+			for x := 0; x < patternSize; x++ {
+				c := pattern[y*patternSize+x]
+				switch i {
+				case 2:
+					// Mirrored horizontally
+					c = pattern[y*patternSize+patternSize-1-x]
+				case 3:
+					// Brightened
+					c.R, c.G, c.B = c.R+40, c.G+40, c.B+40
+				}
+
+				if image.Pt(origin.X+x, origin.Y+y).In(img.Bounds()) {
+					img.SetRGBA(origin.X+x, origin.Y+y, c)
+				}
+			}
+		}
+	}
+
+	return img
+}
+
+// encodeArchive partitions and encodes img with cfg and writes the archive to a temporary file whose path is returned
+func encodeArchive(t testing.TB, img image.Image, cfg *config.Config) string {
+	qti, err := NewQuadtreeImage(img, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = qti.Partition(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encoded, _, err := qti.Encode(context.Background(), ArchiveMode(cfg.Encoding.ArchiveFormat))
+	if err != nil {
+		t.Fatal(err)
+	}
+	encodedBytes, err := ioutil.ReadAll(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "encoded.tar.gz")
+	err = os.WriteFile(path, encodedBytes, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+// decodeArchive decodes the archive at path with cfg into a PNG file and returns its contents
+func decodeArchive(t testing.TB, path string, cfg *config.Config) []byte {
+	decoded, _, err := Decode(context.Background(), path, "decoded.png", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decodedBytes, err := io.ReadAll(decoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return decodedBytes
+}
+
+// countReferences returns the number of leaves of the archive at path that are stored as reference
+func countReferences(t testing.TB, path string) int {
+	archiveReader, err := OpenArchiveReader(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	references := 0
+	for filename, fileContents := range archiveReader.Files() {
+		if !isReservedFile(filename) && isBlockReference(*fileContents) {
+			references++
+		}
+	}
+
+	return references
+}
+
+func TestDecodeStreamingMatchesQuadtreeDecoding(t *testing.T) {
+	// The size isn't a power of two, so the quadtrees have padding
+	img := generateRepetitiveImage(200, 150)
+
+	tests := []struct {
+		name      string
+		configure func(cfg *config.Config)
+	}{
+		{name: "RGB", configure: func(cfg *config.Config) {}},
+		{name: "YCbCr420", configure: func(cfg *config.Config) { cfg.Quadtree.ColorSpace = ColorSpaceYCbCr420 }},
+		{name: "PlanarRGB", configure: func(cfg *config.Config) { cfg.Quadtree.ColorSpace = ColorSpacePlanarRGB }},
+		{name: "Deduplication", configure: func(cfg *config.Config) {
+			cfg.Encoding.DeduplicateBlocks.Enable = true
+			cfg.Encoding.DeduplicateBlocks.Transforms = true
+		}},
+		{name: "Deblocking", configure: func(cfg *config.Config) { cfg.Decoding.Deblocking.Enable = true }},
+		{name: "PlanarDeduplicationDeblocking", configure: func(cfg *config.Config) {
+			cfg.Quadtree.ColorSpace = ColorSpacePlanarYCbCr
+			cfg.Encoding.DeduplicateBlocks.Enable = true
+			cfg.Encoding.DeduplicateBlocks.Transforms = true
+			cfg.Decoding.Deblocking.Enable = true
+			cfg.Decoding.Parallelism = true
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := config.Default()
+			cfg.Quadtree.SimilarityCutoff = 0.95
+			test.configure(cfg)
+
+			path := encodeArchive(t, img, cfg)
+			if cfg.Encoding.DeduplicateBlocks.Enable && countReferences(t, path) == 0 {
+				t.Fatal("no blocks were deduplicated")
+			}
+
+			cfg.Decoding.Streaming.Enable = true
+			streamed := decodeArchive(t, path, cfg)
+
+			cfg.Decoding.Streaming.Enable = false
+			decoded := decodeArchive(t, path, cfg)
+
+			if !bytes.Equal(streamed, decoded) {
+				t.Error("streaming decoding differs from decoding the quadtree")
+			}
+		})
+	}
+}
@@ -0,0 +1,211 @@
+package quadtreeImage
+
+import (
+	"fmt"
+	"image"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/xaverhimmelsbach/quadtree-block-compression/pkg/utils"
+)
+
+// Info summarizes the structure of an encoded quadtree image without decoding its blocks
+type Info struct {
+	// Width of the original image
+	Width int
+	// Height of the original image
+	Height int
+	// Color model the image was encoded in
+	ColorModel utils.ColorModel
+	// Color space the image was partitioned in
+	ColorSpace string
+	// Color space configured for encoding. Planar color spaces fall back to RGB for grayscale, 16-bit and transparent images, so it may differ from ColorSpace.
+	RequestedColorSpace string
+	// Does the original image contain transparent pixels?
+	HasAlpha bool
+	// Quadtrees stored in the archive, one per plane for planar color spaces
+	Trees []TreeInfo
+	// Metadata blocks of the original image file stored in the archive
+	HasExif       bool
+	HasICCProfile bool
+	HasXMP        bool
+}
+
+// TreeInfo summarizes a single quadtree of an encoded quadtree image
+type TreeInfo struct {
+	// Directory of the quadtree inside of the archive, empty if all channels share a single quadtree
+	Name string
+	// Height of the quadtree if every leaf had the size BlockSize
+	TreeHeight int
+	// Number of leaves that store their own block image
+	Blocks int
+	// Number of leaves that are references to the block image of another leaf
+	References int
+	// Number of references that flip, rotate or offset the block image they refer to
+	TransformedReferences int
+	// Number of leaves per depth, starting at the root
+	LeavesPerDepth []int
+}
+
+// Leaves returns the number of leaves stored in the quadtree
+func (t TreeInfo) Leaves() int {
+	return t.Blocks + t.References
+}
+
+// DedupRatio returns the share of leaves that reuse the block image of another leaf
+func (t TreeInfo) DedupRatio() float64 {
+	if t.Leaves() == 0 {
+		return 0
+	}
+	return float64(t.References) / float64(t.Leaves())
+}
+
+// storedTree describes where and in which dimensions a quadtree is stored in an archive
+type storedTree struct {
+	// Directory of the quadtree inside of the archive
+	pathPrefix string
+	// Height of the quadtree if every leaf had the size BlockSize
+	treeHeight int
+	// Bounds of the image that is partitioned by the quadtree
+	bounds image.Rectangle
+}
+
+// getStoredTrees returns the quadtrees an archive with the metadata meta consists of
+func getStoredTrees(meta *metadata) ([]storedTree, error) {
+	space, err := getColorSpace(meta.colorSpace)
+	if err != nil {
+		return nil, err
+	}
+
+	bounds := image.Rect(0, 0, meta.width, meta.height)
+	if !space.isPlanar() {
+		return []storedTree{{pathPrefix: "", treeHeight: meta.treeHeight, bounds: bounds}}, nil
+	}
+
+	trees := make([]storedTree, 0, len(space.planes))
+	for _, p := range space.planes {
+		planeBounds := p.getPlaneBounds(bounds)
+		trees = append(trees, storedTree{
+			pathPrefix: p.name,
+			treeHeight: getTreeHeight(getPaddedSideLength(planeBounds)),
+			bounds:     planeBounds,
+		})
+	}
+
+	return trees, nil
+}
+
+// getLeafFilenames returns the sorted names of all leaf files of archiveReader that belong to tree
+func (tree storedTree) getLeafFilenames(archiveReader *ArchiveReader) []string {
+	filenames := make([]string, 0)
+	for filename := range archiveReader.Files() {
+		if _, ok := trimPath(tree.pathPrefix, filename); ok && !isReservedFile(filename) {
+			filenames = append(filenames, filename)
+		}
+	}
+	sort.Strings(filenames)
+
+	return filenames
+}
+
+// parseTreePath returns the child indices of a path inside of a quadtree of height treeHeight
+func parseTreePath(treePath string, treeHeight int) ([]int, error) {
+	if treePath == "" {
+		return nil, nil
+	}
+
+	childIds := strings.Split(treePath, "/")
+	if len(childIds) > treeHeight {
+		return nil, fmt.Errorf("path %s is deeper than the tree height %d", treePath, treeHeight)
+	}
+
+	childIndices := make([]int, 0, len(childIds))
+	for _, childId := range childIds {
+		childIndex, err := strconv.Atoi(childId)
+		if err != nil {
+			return nil, err
+		}
+
+		// Sanity check childIndex
+		if childIndex < 0 || childIndex >= ChildCount {
+			return nil, fmt.Errorf("childId %d of path %s is not a valid child index (child count %d)", childIndex, treePath, ChildCount)
+		}
+
+		childIndices = append(childIndices, childIndex)
+	}
+
+	return childIndices, nil
+}
+
+// Inspect reads the structure of an encoded quadtree image from reader without decoding any block images
+func Inspect(reader io.Reader) (*Info, error) {
+	archiveReader, err := NewArchiveReader(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	meta, err := readMetadata(archiveReader)
+	if err != nil {
+		return nil, err
+	}
+
+	trees, err := getStoredTrees(meta)
+	if err != nil {
+		return nil, err
+	}
+
+	imageMetadata := readImageMetadata(archiveReader)
+	info := &Info{
+		Width:               meta.width,
+		Height:              meta.height,
+		ColorModel:          meta.colorModel,
+		ColorSpace:          meta.colorSpace,
+		RequestedColorSpace: meta.requestedColorSpace,
+		HasAlpha:            meta.hasAlpha,
+		HasExif:             len(imageMetadata.Exif) > 0,
+		HasICCProfile:       len(imageMetadata.ICCProfile) > 0,
+		HasXMP:              len(imageMetadata.XMP) > 0,
+	}
+
+	for _, tree := range trees {
+		treeInfo := TreeInfo{
+			Name:           tree.pathPrefix,
+			TreeHeight:     tree.treeHeight,
+			LeavesPerDepth: make([]int, tree.treeHeight+1),
+		}
+
+		for _, filename := range tree.getLeafFilenames(archiveReader) {
+			treePath, _ := trimPath(tree.pathPrefix, filename)
+			childIndices, err := parseTreePath(treePath, tree.treeHeight)
+			if err != nil {
+				return nil, err
+			}
+			treeInfo.LeavesPerDepth[len(childIndices)]++
+
+			fileContents, err := archiveReader.Open(filename)
+			if err != nil {
+				return nil, err
+			}
+
+			reference, isReference, err := parseLeafFile(*fileContents, !meta.hasReferenceRecords)
+			if err != nil {
+				return nil, fmt.Errorf("leaf %s: %w", filename, err)
+			}
+
+			if isReference {
+				treeInfo.References++
+				if !reference.transform.isIdentity() {
+					treeInfo.TransformedReferences++
+				}
+			} else {
+				treeInfo.Blocks++
+			}
+		}
+
+		info.Trees = append(info.Trees, treeInfo)
+	}
+
+	return info, nil
+}
@@ -0,0 +1,139 @@
+package quadtreeImage
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"testing"
+
+	"github.com/xaverhimmelsbach/quadtree-block-compression/pkg/config"
+)
+
+func TestInspectReportsAlpha(t *testing.T) {
+	opaque := generateRepetitiveImage(200, 150)
+
+	// A single transparent pixel outside of the first tile
+	transparent := image.NewRGBA(opaque.Bounds())
+	for y := 0; y < 150; y++ {
+		for x := 0; x < 200; x++ {
+			transparent.Set(x, y, opaque.At(x, y))
+		}
+	}
+	transparent.SetRGBA(150, 100, color.RGBA{R: 10, G: 20, B: 30, A: 128})
+
+	cfg := config.Default()
+	cfg.Encoding.Tiling.TileSize = 64
+
+	for _, test := range []struct {
+		name     string
+		img      image.Image
+		hasAlpha bool
+	}{
+		{name: "opaque", img: opaque, hasAlpha: false},
+		{name: "transparent", img: transparent, hasAlpha: true},
+	} {
+		archive, err := os.ReadFile(encodeArchive(t, test.img, cfg))
+		if err != nil {
+			t.Fatal(err)
+		}
+		info, err := Inspect(bytes.NewReader(archive))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.HasAlpha != test.hasAlpha {
+			t.Errorf("%s: Encode stored the alpha flag %t, want %t", test.name, info.HasAlpha, test.hasAlpha)
+		}
+
+		tiled := new(bytes.Buffer)
+		err = EncodeTiles(context.Background(), NewImageTileSource(test.img), tiled, cfg, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		info, err = Inspect(tiled)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.HasAlpha != test.hasAlpha {
+			t.Errorf("%s: EncodeTiles stored the alpha flag %t, want %t", test.name, info.HasAlpha, test.hasAlpha)
+		}
+	}
+}
+
+// inspectArchive returns the structure of the archive at path
+func inspectArchive(t *testing.T, path string) *Info {
+	archive, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := Inspect(bytes.NewReader(archive))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return info
+}
+
+func TestPlanarColorSpacesFallBackToRGB(t *testing.T) {
+	opaque := generateRepetitiveImage(96, 64)
+	bounds := opaque.Bounds()
+
+	gray := image.NewGray(bounds)
+	deep := image.NewRGBA64(bounds)
+	transparent := image.NewRGBA(bounds)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			gray.Set(x, y, opaque.At(x, y))
+			deep.Set(x, y, opaque.At(x, y))
+			transparent.Set(x, y, opaque.At(x, y))
+		}
+	}
+	transparent.SetRGBA(40, 30, color.RGBA{A: 0})
+
+	// Only opaque 8-bit RGB images are split into planes
+	images := []struct {
+		name   string
+		img    image.Image
+		planar bool
+	}{
+		{name: "opaque", img: opaque, planar: true},
+		{name: "gray", img: gray},
+		{name: "16-bit", img: deep},
+		{name: "transparent", img: transparent},
+	}
+
+	for _, requested := range []string{ColorSpaceYCbCr420, ColorSpacePlanarYCbCr, ColorSpacePlanarRGB} {
+		for _, test := range images {
+			cfg := config.Default()
+			cfg.Quadtree.ColorSpace = requested
+
+			path := encodeArchive(t, test.img, cfg)
+			info := inspectArchive(t, path)
+			want, wantTrees := ColorSpaceRGB, 1
+			if test.planar {
+				want, wantTrees = requested, 3
+			}
+			if info.ColorSpace != want || info.RequestedColorSpace != requested {
+				t.Errorf("%s %s: archive records the color space %s and the requested %s, want %s and %s", requested, test.name, info.ColorSpace, info.RequestedColorSpace, want, requested)
+			}
+			if len(info.Trees) != wantTrees {
+				t.Errorf("%s %s: archive holds %d quadtrees, want %d", requested, test.name, len(info.Trees), wantTrees)
+			}
+
+			decoded, err := png.Decode(bytes.NewReader(decodeArchive(t, path, cfg)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if decoded.Bounds() != bounds {
+				t.Errorf("%s %s: decoded image has the bounds %v, want %v", requested, test.name, decoded.Bounds(), bounds)
+			}
+		}
+	}
+
+	// Archives of RGB images record RGB as requested color space
+	info := inspectArchive(t, encodeArchive(t, opaque, config.Default()))
+	if info.ColorSpace != ColorSpaceRGB || info.RequestedColorSpace != ColorSpaceRGB {
+		t.Errorf("RGB archive records the color space %s and the requested %s", info.ColorSpace, info.RequestedColorSpace)
+	}
+}
@@ -0,0 +1,143 @@
+package quadtreeImage
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/xaverhimmelsbach/quadtree-block-compression/pkg/utils"
+)
+
+const (
+	// metaKeyAlpha marks whether the encoded image contains transparent pixels
+	metaKeyAlpha = "alpha"
+	// metaKeyColorModel holds the color model the image was encoded in
+	metaKeyColorModel = "colorModel"
+	// metaKeyColorSpace holds the color space the image was partitioned in
+	metaKeyColorSpace = "colorSpace"
+	// metaKeyRequestedColorSpace holds the color space configured for encoding, which differs from the one in metaKeyColorSpace if the image didn't support its planes
+	metaKeyRequestedColorSpace = "requestedColorSpace"
+	// metaKeyReferenceRecords marks whether deduplicated leaves are stored as reference records
+	metaKeyReferenceRecords = "referenceRecords"
+)
+
+// metadata holds the global information about an encoded quadtree image that is stored in MetaFile.
+// The first three lines of MetaFile hold treeHeight, width and height, optional fields follow as key=value lines.
+type metadata struct {
+	// Height of the quadtree if every leaf had the size BlockSize
+	treeHeight int
+	// Width of the original image
+	width int
+	// Height of the original image
+	height int
+	// Does the original image contain transparent pixels?
+	hasAlpha bool
+	// Color model of the original image and its blocks
+	colorModel utils.ColorModel
+	// Color space the image was partitioned in
+	colorSpace string
+	// Color space configured for encoding. Planar color spaces fall back to ColorSpaceRGB for images that supportsPlanes rejects.
+	requestedColorSpace string
+	// Are deduplicated leaves stored as reference records? Older files store them as pseudo symlinks
+	hasReferenceRecords bool
+}
+
+// readMetadata parses the MetaFile of an archive
+func readMetadata(archiveReader *ArchiveReader) (*metadata, error) {
+	metaBytes, err := archiveReader.Open(MetaFile)
+	if err != nil {
+		return nil, err
+	}
+
+	meta := strings.Split(string(*metaBytes), "\n")
+	if len(meta) < 3 {
+		return nil, fmt.Errorf("meta file contained %d newline-seperated values instead of at least three", len(meta))
+	}
+
+	// Files written before the color model and color space were recorded are always RGBA
+	m := &metadata{colorModel: utils.ColorModelRGBA, colorSpace: ColorSpaceRGB}
+
+	m.treeHeight, err = strconv.Atoi(meta[0])
+	if err != nil {
+		return nil, err
+	}
+
+	m.width, err = strconv.Atoi(meta[1])
+	if err != nil {
+		return nil, err
+	}
+
+	m.height, err = strconv.Atoi(meta[2])
+	if err != nil {
+		return nil, err
+	}
+
+	// Parse optional fields
+	for _, line := range meta[3:] {
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("meta file line %q is not a key=value pair", line)
+		}
+
+		switch key {
+		case metaKeyAlpha:
+			m.hasAlpha, err = strconv.ParseBool(value)
+		case metaKeyColorModel:
+			m.colorModel, err = utils.ParseColorModel(value)
+		case metaKeyColorSpace:
+			m.colorSpace = value
+		case metaKeyRequestedColorSpace:
+			m.requestedColorSpace = value
+		case metaKeyReferenceRecords:
+			m.hasReferenceRecords, err = strconv.ParseBool(value)
+		default:
+			// Ignore unknown fields to stay compatible with files written by newer versions
+		}
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Files written before the requested color space was recorded don't tell whether they fell back
+	if m.requestedColorSpace == "" {
+		m.requestedColorSpace = m.colorSpace
+	}
+
+	return m, nil
+}
+
+// reader serializes the metadata into the format expected by readMetadata
+func (m *metadata) reader() io.Reader {
+	metaBuffer := new(bytes.Buffer)
+	metaBuffer.Write([]byte(strconv.Itoa(m.treeHeight) + "\n" +
+		strconv.Itoa(m.width) + "\n" +
+		strconv.Itoa(m.height)))
+
+	metaBuffer.Write([]byte("\n" + metaKeyAlpha + "=" + strconv.FormatBool(m.hasAlpha)))
+	metaBuffer.Write([]byte("\n" + metaKeyColorModel + "=" + string(m.colorModel)))
+	metaBuffer.Write([]byte("\n" + metaKeyColorSpace + "=" + m.colorSpace))
+	metaBuffer.Write([]byte("\n" + metaKeyRequestedColorSpace + "=" + m.requestedColorSpace))
+	metaBuffer.Write([]byte("\n" + metaKeyReferenceRecords + "=" + strconv.FormatBool(m.hasReferenceRecords)))
+
+	return metaBuffer
+}
+
+// readImageMetadata collects the metadata blocks of the original image file stored in an archive
+func readImageMetadata(archiveReader *ArchiveReader) *utils.ImageMetadata {
+	imageMetadata := new(utils.ImageMetadata)
+
+	if exif, err := archiveReader.Open(ExifFile); err == nil {
+		imageMetadata.Exif = *exif
+	}
+	if iccProfile, err := archiveReader.Open(ICCProfileFile); err == nil {
+		imageMetadata.ICCProfile = *iccProfile
+	}
+	if xmp, err := archiveReader.Open(XMPFile); err == nil {
+		imageMetadata.XMP = *xmp
+	}
+
+	return imageMetadata
+}
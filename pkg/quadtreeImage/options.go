@@ -0,0 +1,69 @@
+package quadtreeImage
+
+import (
+	"sort"
+
+	"github.com/xaverhimmelsbach/quadtree-block-compression/pkg/config"
+)
+
+// ConfigOptions returns the values the config options selecting interpolators, similarity metrics, color spaces, planes, archive and block formats accept.
+// The lists are taken from the registries of this package, so config validation can't drift from them.
+func ConfigOptions() config.Options {
+	return config.Options{
+		Interpolators:     InterpolatorIds(),
+		SimilarityMetrics: SimilarityMetricIds(),
+		ColorSpaces:       ColorSpaceIds(),
+		Planes:            PlaneIds(),
+		ArchiveFormats:    []string{string(ArchiveModeGzip), string(ArchiveModeZip)},
+		BlockFormats:      []string{BlockFormatAuto, BlockFormatPNG},
+	}
+}
+
+// InterpolatorIds returns the sorted ids of all interpolators that can be used for down- and upsampling
+func InterpolatorIds() []string {
+	ids := make([]string, 0, len(interpolators))
+	for id := range interpolators {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	return ids
+}
+
+// SimilarityMetricIds returns the sorted ids of all metrics that can measure the similarity of base and upsampled image
+func SimilarityMetricIds() []string {
+	ids := make([]string, 0, len(similarityMetrics))
+	for id := range similarityMetrics {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	return ids
+}
+
+// ColorSpaceIds returns the sorted ids of all color spaces quadtrees can be partitioned in
+func ColorSpaceIds() []string {
+	ids := make([]string, 0, len(colorSpaces))
+	for id := range colorSpaces {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	return ids
+}
+
+// PlaneIds returns the names of all planes of the planar color spaces, in the order of ColorSpaceIds and their planes
+func PlaneIds() []string {
+	ids := make([]string, 0)
+	isListed := make(map[string]bool)
+	for _, colorSpaceId := range ColorSpaceIds() {
+		for _, p := range colorSpaces[colorSpaceId].planes {
+			if !isListed[p.name] {
+				ids = append(ids, p.name)
+				isListed[p.name] = true
+			}
+		}
+	}
+
+	return ids
+}
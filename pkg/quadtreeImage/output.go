@@ -0,0 +1,35 @@
+package quadtreeImage
+
+import (
+	"path"
+
+	"github.com/xaverhimmelsbach/quadtree-block-compression/pkg/config"
+	"github.com/xaverhimmelsbach/quadtree-block-compression/pkg/utils"
+)
+
+// GetOutputFormat returns the format decoded images are written in.
+// The configured format takes precedence over the extension of outputPath, PNG is used if neither is set.
+func GetOutputFormat(cfg *config.Config, outputPath string) (string, error) {
+	format := cfg.Decoding.Output.Format
+	if format == "" {
+		format = path.Ext(outputPath)
+	}
+	if format == "" {
+		format = utils.FormatPNG
+	}
+
+	return utils.GetFormat(format)
+}
+
+// GetWriteOptions returns the encoder settings for decoded images configured in cfg
+func GetWriteOptions(cfg *config.Config) (*utils.WriteOptions, error) {
+	compressionLevel, err := utils.GetPNGCompressionLevel(cfg.Decoding.Output.PNGCompressionLevel)
+	if err != nil {
+		return nil, err
+	}
+
+	return &utils.WriteOptions{
+		JPEGQuality:         cfg.Decoding.Output.JPEGQuality,
+		PNGCompressionLevel: compressionLevel,
+	}, nil
+}
@@ -0,0 +1,232 @@
+package quadtreeImage
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"strings"
+
+	"github.com/xaverhimmelsbach/quadtree-block-compression/pkg/config"
+	"github.com/xaverhimmelsbach/quadtree-block-compression/pkg/utils"
+)
+
+const (
+	// ColorSpaceRGB partitions all color channels together in a single quadtree
+	ColorSpaceRGB = "RGB"
+	// ColorSpaceYCbCr420 partitions the luma plane in full resolution and both chroma planes in half resolution, each in its own quadtree
+	ColorSpaceYCbCr420 = "YCbCr420"
+	// ColorSpacePlanarYCbCr partitions the luma and both chroma planes in full resolution, each in its own quadtree
+	ColorSpacePlanarYCbCr = "PlanarYCbCr"
+	// ColorSpacePlanarRGB partitions the red, green and blue planes in full resolution, each in its own quadtree
+	ColorSpacePlanarRGB = "PlanarRGB"
+)
+
+// plane describes one of the planes a color space splits an image into
+type plane struct {
+	// Name of the plane, used as the directory of its quadtree in the archive
+	name string
+	// Factor by which the plane is downsampled in relation to the original image
+	subsampling int
+}
+
+// colorSpace describes how an image is split into planes that are partitioned independently and how they are merged again
+type colorSpace struct {
+	// Planes that are partitioned independently. Empty for color spaces that keep all channels in a single quadtree.
+	planes []plane
+	// Splits an image into full resolution planes in the order of planes
+	split func(img image.Image) []*image.Gray
+	// Merges full resolution planes in the order of planes into a single image
+	merge func(planes []image.Image) draw.Image
+}
+
+// colorSpaces holds the different color spaces quadtrees can be partitioned in
+var colorSpaces = map[string]colorSpace{
+	ColorSpaceRGB: {},
+	ColorSpaceYCbCr420: {
+		planes: []plane{
+			{name: "Y", subsampling: 1},
+			{name: "Cb", subsampling: 2},
+			{name: "Cr", subsampling: 2},
+		},
+		split: splitYCbCr,
+		merge: mergeYCbCr,
+	},
+	ColorSpacePlanarYCbCr: {
+		planes: []plane{
+			{name: "Y", subsampling: 1},
+			{name: "Cb", subsampling: 1},
+			{name: "Cr", subsampling: 1},
+		},
+		split: splitYCbCr,
+		merge: mergeYCbCr,
+	},
+	ColorSpacePlanarRGB: {
+		planes: []plane{
+			{name: "R", subsampling: 1},
+			{name: "G", subsampling: 1},
+			{name: "B", subsampling: 1},
+		},
+		split: splitRGB,
+		merge: mergeRGB,
+	},
+}
+
+// getColorSpace returns the correct color space for a colorSpaceId from colorSpaces.
+// An empty colorSpaceId selects ColorSpaceRGB.
+func getColorSpace(colorSpaceId string) (colorSpace, error) {
+	if colorSpaceId == "" {
+		colorSpaceId = ColorSpaceRGB
+	}
+
+	space, ok := colorSpaces[colorSpaceId]
+	var err error
+	if !ok {
+		err = fmt.Errorf("color space id not found: %q", colorSpaceId)
+	}
+	return space, err
+}
+
+// isPlanar returns whether the color space partitions its planes in separate quadtrees
+func (c colorSpace) isPlanar() bool {
+	return len(c.planes) > 0
+}
+
+// getPlaneBounds returns the bounds of a plane of an image with the given bounds
+func (p plane) getPlaneBounds(bounds image.Rectangle) image.Rectangle {
+	return image.Rect(0, 0, (bounds.Dx()+p.subsampling-1)/p.subsampling, (bounds.Dy()+p.subsampling-1)/p.subsampling)
+}
+
+// splitYCbCr splits img into its luma and chroma planes
+func splitYCbCr(img image.Image) []*image.Gray {
+	bounds := img.Bounds()
+	planeBounds := image.Rect(0, 0, bounds.Dx(), bounds.Dy())
+	yPlane := image.NewGray(planeBounds)
+	cbPlane := image.NewGray(planeBounds)
+	crPlane := image.NewGray(planeBounds)
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := color.YCbCrModel.Convert(img.At(x, y)).(color.YCbCr)
+			yPlane.SetGray(x-bounds.Min.X, y-bounds.Min.Y, color.Gray{Y: c.Y})
+			cbPlane.SetGray(x-bounds.Min.X, y-bounds.Min.Y, color.Gray{Y: c.Cb})
+			crPlane.SetGray(x-bounds.Min.X, y-bounds.Min.Y, color.Gray{Y: c.Cr})
+		}
+	}
+
+	return []*image.Gray{yPlane, cbPlane, crPlane}
+}
+
+// mergeYCbCr merges luma and chroma planes into a single RGBA image
+func mergeYCbCr(planes []image.Image) draw.Image {
+	bounds := planes[0].Bounds()
+	mergedImage := image.NewRGBA(bounds)
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			mergedImage.Set(x, y, color.YCbCr{
+				Y:  color.GrayModel.Convert(planes[0].At(x, y)).(color.Gray).Y,
+				Cb: color.GrayModel.Convert(planes[1].At(x, y)).(color.Gray).Y,
+				Cr: color.GrayModel.Convert(planes[2].At(x, y)).(color.Gray).Y,
+			})
+		}
+	}
+
+	return mergedImage
+}
+
+// splitRGB splits img into its red, green and blue planes
+func splitRGB(img image.Image) []*image.Gray {
+	bounds := img.Bounds()
+	planeBounds := image.Rect(0, 0, bounds.Dx(), bounds.Dy())
+	rPlane := image.NewGray(planeBounds)
+	gPlane := image.NewGray(planeBounds)
+	bPlane := image.NewGray(planeBounds)
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := color.RGBAModel.Convert(img.At(x, y)).(color.RGBA)
+			rPlane.SetGray(x-bounds.Min.X, y-bounds.Min.Y, color.Gray{Y: c.R})
+			gPlane.SetGray(x-bounds.Min.X, y-bounds.Min.Y, color.Gray{Y: c.G})
+			bPlane.SetGray(x-bounds.Min.X, y-bounds.Min.Y, color.Gray{Y: c.B})
+		}
+	}
+
+	return []*image.Gray{rPlane, gPlane, bPlane}
+}
+
+// mergeRGB merges red, green and blue planes into a single RGBA image
+func mergeRGB(planes []image.Image) draw.Image {
+	bounds := planes[0].Bounds()
+	mergedImage := image.NewRGBA(bounds)
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			mergedImage.SetRGBA(x, y, color.RGBA{
+				R: color.GrayModel.Convert(planes[0].At(x, y)).(color.Gray).Y,
+				G: color.GrayModel.Convert(planes[1].At(x, y)).(color.Gray).Y,
+				B: color.GrayModel.Convert(planes[2].At(x, y)).(color.Gray).Y,
+				A: 255,
+			})
+		}
+	}
+
+	return mergedImage
+}
+
+// getPlaneConfig returns a copy of cfg that uses the similarity cutoff configured for the plane p
+func getPlaneConfig(cfg *config.Config, p plane) *config.Config {
+	cutoff, ok := cfg.Quadtree.PlaneSimilarityCutoffs[p.name]
+	if !ok {
+		return cfg
+	}
+
+	planeConfig := *cfg
+	planeConfig.Quadtree.SimilarityCutoff = cutoff
+	return &planeConfig
+}
+
+// supportsPlanes returns whether img can be split into the planes of a color space without losing information.
+// Planes are 8-bit without alpha, so only opaque RGBA images qualify.
+func supportsPlanes(img image.Image) bool {
+	return utils.GetColorModel(img) == utils.ColorModelRGBA && utils.IsOpaque(img)
+}
+
+// joinPath joins the path of a file in a quadtree with the directory of its quadtree in the archive
+func joinPath(pathPrefix string, path string) string {
+	if pathPrefix == "" {
+		return path
+	}
+	if path == "" {
+		return pathPrefix
+	}
+	return pathPrefix + "/" + path
+}
+
+// getLeafPath returns the path of the file of the leaf with the identifier id in the quadtree stored in the directory pathPrefix of the archive
+func getLeafPath(pathPrefix string, id string) string {
+	return joinPath(pathPrefix, strings.Join(strings.Split(id, ""), "/"))
+}
+
+// trimPath removes the directory of a quadtree from the path of one of its files.
+// It returns false if the file doesn't belong to the quadtree.
+func trimPath(pathPrefix string, path string) (string, bool) {
+	if pathPrefix == "" {
+		return path, true
+	}
+	if path == pathPrefix {
+		return "", true
+	}
+	if !strings.HasPrefix(path, pathPrefix+"/") {
+		return "", false
+	}
+	return strings.TrimPrefix(path, pathPrefix+"/"), true
+}
+
+// getPathDepth returns the depth of the node a path inside of a quadtree leads to, the root having depth 0
+func getPathDepth(path string) int {
+	if path == "" {
+		return 0
+	}
+	return strings.Count(path, "/") + 1
+}
@@ -0,0 +1,111 @@
+package quadtreeImage
+
+import (
+	"context"
+	"sync"
+)
+
+const (
+	// StagePartition reports the nodes created while partitioning an image into a quadtree
+	StagePartition = "partition"
+	// StageEncode reports the leaves written to the archive
+	StageEncode = "encode"
+	// StageDecode reports the leaves read from the archive
+	StageDecode = "decode"
+)
+
+// Progress describes how far a stage of encoding or decoding has come
+type Progress struct {
+	// Stage the progress belongs to, one of StagePartition, StageEncode and StageDecode
+	Stage string
+	// Number of quadtree nodes processed so far
+	NodesProcessed int
+	// Estimated number of nodes processed by the stage in total.
+	// It is exact for StageEncode and StageDecode and extrapolated from the area covered by finished leaves for StagePartition.
+	EstimatedTotal int
+	// Depth of the last processed node, the root having depth 0
+	Depth int
+}
+
+// ProgressFunc receives progress updates. Calls never overlap, but may come from different goroutines.
+type ProgressFunc func(progress Progress)
+
+// progressKey is the key of the ProgressFunc stored in a context
+type progressKey struct{}
+
+// WithProgress returns a copy of ctx that makes Partition, Encode and Decode report their progress to onProgress
+func WithProgress(ctx context.Context, onProgress ProgressFunc) context.Context {
+	return context.WithValue(ctx, progressKey{}, onProgress)
+}
+
+// progressTracker counts the processed nodes of a stage and forwards them to the ProgressFunc of a context.
+// A nil progressTracker ignores all updates.
+type progressTracker struct {
+	stage      string
+	onProgress ProgressFunc
+	// Number of nodes processed so far
+	processed int
+	// Number of nodes processed in total, 0 if it has to be extrapolated
+	total int
+	// Share of the area of all quadtrees that is covered by finished leaves
+	coveredArea float64
+	// Serializes updates and calls of onProgress
+	mutex sync.Mutex
+}
+
+// newProgressTracker returns a progressTracker for stage, or nil if ctx holds no ProgressFunc.
+// total is the number of nodes processed by the stage, 0 if it is unknown.
+func newProgressTracker(ctx context.Context, stage string, total int) *progressTracker {
+	onProgress, ok := ctx.Value(progressKey{}).(ProgressFunc)
+	if !ok || onProgress == nil {
+		return nil
+	}
+
+	return &progressTracker{
+		stage:      stage,
+		onProgress: onProgress,
+		total:      total,
+	}
+}
+
+// add records a processed node at depth that finished a share of leafArea of the area of all quadtrees
+func (p *progressTracker) add(depth int, leafArea float64) {
+	if p == nil {
+		return
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.processed++
+	p.coveredArea += leafArea
+
+	p.onProgress(Progress{
+		Stage:          p.stage,
+		NodesProcessed: p.processed,
+		EstimatedTotal: p.getEstimatedTotal(),
+		Depth:          depth,
+	})
+}
+
+// getEstimatedTotal returns the known total or extrapolates it from the processed nodes and the covered area
+func (p *progressTracker) getEstimatedTotal() int {
+	if p.total > 0 {
+		return p.total
+	}
+	if p.coveredArea <= 0 {
+		return p.processed
+	}
+
+	// Assume that the uncovered area is partitioned as finely as the covered area
+	estimatedTotal := int(float64(p.processed) / p.coveredArea)
+	if estimatedTotal < p.processed {
+		return p.processed
+	}
+	return estimatedTotal
+}
+
+// getNodeArea returns the share of the area of all quadtrees covered by a node at depth in one of treeCount equally weighted quadtrees
+func getNodeArea(depth int, treeCount int) float64 {
+	return 1 / float64(treeCount) / float64(int64(1)<<(2*depth))
+}
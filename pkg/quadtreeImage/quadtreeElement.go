@@ -2,15 +2,17 @@ package quadtreeImage
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"image"
 	"image/draw"
 	"image/jpeg"
+	"image/png"
+	"io"
 	"strconv"
 	"strings"
 	"sync"
 
-	"github.com/h2non/filetype"
 	"github.com/xaverhimmelsbach/quadtree-block-compression/pkg/config"
 	"github.com/xaverhimmelsbach/quadtree-block-compression/pkg/utils"
 	drawX "golang.org/x/image/draw"
@@ -24,6 +26,15 @@ var interpolators = map[string]drawX.Interpolator{
 	"CatmullRom":      drawX.CatmullRom,
 }
 
+// similarityMetric measures the similarity of two images inside of globalBounds, ranging from 0 (no matches) to 1 (identical pictures)
+type similarityMetric func(imageA image.Image, imageB image.Image, globalBounds image.Rectangle) (float64, error)
+
+// similarityMetrics holds the different metrics that can decide whether a block image is similar enough to its base image
+var similarityMetrics = map[string]similarityMetric{
+	"Weighted": utils.ComparePixelsWeighted,
+	"Exact":    utils.ComparePixelsExact,
+}
+
 // QuadtreeElement represents a node in the quadtree that can either be the parent of ChildCount children or contain a block image
 type QuadtreeElement struct {
 	// The section of the original image (with padding) that this QuadtreeElement occupies
@@ -32,14 +43,14 @@ type QuadtreeElement struct {
 	blockImageMinimal *image.Image
 	// blockImageMinimal scaled back up to the size of baseImage
 	blockImage image.Image
+	// Transform turning blockImageMinimal into the block of this QuadtreeElement if it was deduplicated with a transformed block
+	blockTransform blockTransform
 	// Children of this QuadtreeElement in the quadtree
 	children []*QuadtreeElement
 	// Bounding box of the original image, used for out-of-bounds-check
 	globalBounds *image.Rectangle
-	// List of all currently existing quadtree blocks of size BlockSize
-	existingBlocks **[]*image.Image
-	// Regulate access to existingBlocks
-	existingBlocksMutex *sync.RWMutex
+	// Minimal blocks of all leaves of the quadtree created so far, used for deduplication
+	blocks *blockIndex
 	// Is this QuadtreeElement a leaf and does it therefore contain an actual blockImage?
 	isLeaf bool
 	// Can this block be skipped during encoding?
@@ -61,81 +72,45 @@ type VisualizationElement struct {
 }
 
 // NewQuadtreeElement returns a fully populated QuadtreeImage occupying the space of baseImage
-func NewQuadtreeElement(id string, baseImage image.Image, globalBounds *image.Rectangle, existingBlocks **[]*image.Image, existingBlocksMutex *sync.RWMutex, cfg *config.Config) *QuadtreeElement {
+func NewQuadtreeElement(id string, baseImage image.Image, globalBounds *image.Rectangle, blocks *blockIndex, cfg *config.Config) *QuadtreeElement {
 	qte := new(QuadtreeElement)
 
 	qte.id = id
 	qte.config = cfg
 	qte.baseImage = baseImage
 	qte.globalBounds = globalBounds
-	qte.existingBlocks = existingBlocks
-	qte.existingBlocksMutex = existingBlocksMutex
-	qte.blockImage, qte.blockImageMinimal = qte.createBlockImages()
+	qte.blocks = blocks
+	var isDeduplicated bool
+	qte.blockImage, qte.blockImageMinimal, isDeduplicated = qte.createBlockImages()
+	qte.blockTransform = identityTransform
 	qte.isLeaf, qte.canBeSkipped = qte.checkIsLeaf()
 
-	return qte
-}
-
-// partition splits the BaseImage into ChildCount subimages if further partitioning is required, and calls their partition methods
-func (q *QuadtreeElement) partition(parentWg *sync.WaitGroup) {
-	q.children = make([]*QuadtreeElement, 0)
-
-	// WaitGroup for use in parallelized partition
-	var wg sync.WaitGroup
-
-	if !q.isLeaf {
-		// Partition BaseImage into sub images
-		for i := 0; i < ChildCount; i++ {
-			// TODO: this approach probably can't handle cases of ChildCount != 4
-			var xStart, yStart, xEnd, yEnd int
-
-			// Set x coordinates
-			if i&1 == 0 {
-				// Left block
-				xStart = q.baseImage.Bounds().Min.X
-				xEnd = q.baseImage.Bounds().Min.X + q.baseImage.Bounds().Dx()/2
-			} else {
-				// Right block
-				xStart = q.baseImage.Bounds().Min.X + q.baseImage.Bounds().Dx()/2
-				xEnd = q.baseImage.Bounds().Max.X
-			}
-
-			// Set y coordinates
-			if i&2 == 0 {
-				// Upper block
-				yStart = q.baseImage.Bounds().Min.Y
-				yEnd = q.baseImage.Bounds().Min.Y + q.baseImage.Bounds().Dy()/2
-			} else {
-				// Lower block
-				yStart = q.baseImage.Bounds().Min.Y + q.baseImage.Bounds().Dy()/2
-				yEnd = q.baseImage.Bounds().Max.Y
-			}
-
-			// TODO: The next 4 lines are some of the most expensive code in the codebase.
-			// Currently they are all executed in the same thread and parallelized by calling the childrens nodes partition method in parallel. This is not optimal.
-			// Copy BaseImage section to sub image
-			childImage := image.NewRGBA(image.Rect(xStart, yStart, xEnd, yEnd))
-			draw.Draw(childImage, childImage.Bounds(), q.baseImage, childImage.Bounds().Min, draw.Src)
-
-			// Create and partition child
-			child := NewQuadtreeElement(q.id+strconv.Itoa(i), childImage, q.globalBounds, q.existingBlocks, q.existingBlocksMutex, q.config)
-			q.children = append(q.children, child)
-
-			// If parallelism is enabled, partition all children in their own gothread
-			if q.config.Encoding.Parallelism && parentWg != nil {
-				wg.Add(1)
-				go child.partition(&wg)
-			} else {
-				child.partition(nil)
-			}
+	// Only leaves are encoded, so only their blocks can be reused by other leaves
+	isEncoded := !cfg.Encoding.SkipOutOfBoundsBlocks.Enable || !qte.canBeSkipped
+	if cfg.Encoding.DeduplicateBlocks.Enable && qte.isLeaf && isEncoded {
+		if cfg.Encoding.DeduplicateBlocks.Transforms && !isDeduplicated {
+			isDeduplicated = qte.deduplicateTransformed()
+		}
+		if !isDeduplicated {
+			qte.blocks.add(qte.blockImageMinimal)
 		}
 	}
 
-	// If parallelism is enabled, wait for all children to complete partition and signal completion to parent
-	if q.config.Encoding.Parallelism && parentWg != nil {
-		wg.Wait()
-		parentWg.Done()
+	// Partitioned nodes are represented by their children, so their upscaled block image isn't needed anymore
+	if !qte.isLeaf {
+		qte.blockImage = nil
 	}
+
+	return qte
+}
+
+// createChild creates a QuadtreeElement for the section of baseImage belonging to the child with index childIndex.
+// The children of the new element are not partitioned yet.
+func (q *QuadtreeElement) createChild(childIndex int) *QuadtreeElement {
+	childBounds := getChildBounds(q.baseImage.Bounds(), childIndex)
+	childImage := utils.SubImage(q.baseImage, childBounds)
+
+	return NewQuadtreeElement(q.id+strconv.Itoa(childIndex), childImage, q.globalBounds, q.blocks, q.config)
 }
 
 // checkIsLeaf checks whether the current block needs to be partitioned further and if it can be skipped during encoding
@@ -154,78 +129,67 @@ func (q *QuadtreeElement) checkIsLeaf() (bool, bool) {
 	return q.compareImages() > q.config.Quadtree.SimilarityCutoff, false
 }
 
-// createBlockImages scales the baseImage down to BlockSize and then scales it back up to the original size
-func (q *QuadtreeElement) createBlockImages() (image.Image, *image.Image) {
-	baseImage := q.baseImage.(*image.RGBA)
-
+// createBlockImages scales the baseImage down to BlockSize and then scales it back up to the original size.
+// If the downscaled block is deduplicated, the existing block is returned instead and the returned bool is set.
+func (q *QuadtreeElement) createBlockImages() (image.Image, *image.Image, bool) {
 	// Load inteprolators
 	downsamplingInterpolator, err := getInterpolator(q.config.Quadtree.DownsamplingInterpolator)
 	if err != nil {
 		panic(err)
 	}
-	upsamplingInterpolator, err := getInterpolator(q.config.Quadtree.UpsamplingInterpolator)
-	if err != nil {
-		panic(err)
-	}
 
 	// Scale baseImage down to BlockSize
-	downsampledImage := utils.Scale(baseImage, image.Rect(0, 0, BlockSize, BlockSize), downsamplingInterpolator)
-	downsampledImageRGBA := downsampledImage.(*image.RGBA)
+	downsampledImage := utils.Scale(q.baseImage, image.Rect(0, 0, BlockSize, BlockSize), downsamplingInterpolator)
 
 	// Attempt to deduplicate blocks
 	if q.config.Encoding.DeduplicateBlocks.Enable {
-		bestSimilarity := 0.0
-		var bestBlock *image.Image
-		var bestBlockRGBA *image.RGBA
-
-		// Compare existing blocks with current block
-		q.existingBlocksMutex.RLock()
-		for _, otherImage := range **q.existingBlocks {
-			otherImageRGBA := (*otherImage).(*image.RGBA)
-
-			// Compute similarity
-			similarity, err := utils.ComparePixelsWeighted(downsampledImageRGBA, otherImageRGBA, downsampledImageRGBA.Rect)
-			if err != nil {
-				panic(err)
-			}
-
-			// Apply new best block match
-			if similarity > bestSimilarity {
-				bestSimilarity = similarity
-				bestBlock = otherImage
-				bestBlockRGBA = otherImageRGBA
-			}
-		}
-		q.existingBlocksMutex.RUnlock()
-
 		// If a block was found that is sufficiently similar
-		if bestBlock != nil && bestSimilarity >= q.config.Encoding.DeduplicateBlocks.MinimalSimilarity {
-			// Scale downsampled image back up to size of baseImage
-			blockImage := utils.Scale(bestBlockRGBA, q.baseImage.Bounds(), upsamplingInterpolator).(*image.RGBA)
-			return blockImage, bestBlock
+		if bestBlock, _ := q.blocks.findSimilar(downsampledImage, q.config.Encoding.DeduplicateBlocks.MinimalSimilarity); bestBlock != nil {
+			return q.upsampleBlock(*bestBlock), bestBlock, true
 		}
 	}
 
 	// If no sufficiently similar existing block was found or deduplication is disabled
-	// Scale downsampled image back up to size of baseImage
-	blockImage := utils.Scale(downsampledImageRGBA, q.baseImage.Bounds(), upsamplingInterpolator).(*image.RGBA)
+	return q.upsampleBlock(downsampledImage), &downsampledImage, false
+}
+
+// deduplicateTransformed replaces the block of a leaf with a flipped, rotated or offset block of an earlier leaf and returns whether it did.
+// Transforms are only searched for leaves, as searching all orientations costs as much as searching near-duplicates eight times.
+// The block is only replaced if the leaf would still not be partitioned with it.
+func (q *QuadtreeElement) deduplicateTransformed() bool {
+	bestBlock, transform := q.blocks.findTransformed(*q.blockImageMinimal, q.config.Encoding.DeduplicateBlocks.MinimalSimilarity)
+	if bestBlock == nil {
+		return false
+	}
 
-	// Add to global blocks
-	// Take detour over pointer pointer as not to invalidate pointers to globalBlocks in other quadtreeImages
-	q.existingBlocksMutex.Lock()
-	newBlocks := append(**q.existingBlocks, &downsampledImage)
-	*q.existingBlocks = &newBlocks
-	q.existingBlocksMutex.Unlock()
+	blockImage, blockImageMinimal := q.blockImage, q.blockImageMinimal
+	q.blockImage, q.blockImageMinimal, q.blockTransform = q.upsampleBlock(transform.apply(*bestBlock)), bestBlock, transform
+	if isLeaf, _ := q.checkIsLeaf(); !isLeaf {
+		q.blockImage, q.blockImageMinimal, q.blockTransform = blockImage, blockImageMinimal, identityTransform
+		return false
+	}
+
+	return true
+}
+
+// upsampleBlock scales block up to the size of baseImage
+func (q *QuadtreeElement) upsampleBlock(block image.Image) image.Image {
+	upsamplingInterpolator, err := getInterpolator(q.config.Quadtree.UpsamplingInterpolator)
+	if err != nil {
+		panic(err)
+	}
 
-	return blockImage, &downsampledImage
+	return utils.Scale(block, q.baseImage.Bounds(), upsamplingInterpolator)
 }
 
-// compareImages compares blockImage with baseImage
+// compareImages compares blockImage with baseImage using the configured similarity metric
 func (q *QuadtreeElement) compareImages() float64 {
-	baseImage := q.baseImage.(*image.RGBA)
-	blockImage := q.blockImage.(*image.RGBA)
+	metric, err := getSimilarityMetric(q.config.Quadtree.SimilarityMetric)
+	if err != nil {
+		panic(err)
+	}
 
-	similarity, err := utils.ComparePixelsWeighted(blockImage, baseImage, *q.globalBounds)
+	similarity, err := metric(q.blockImage, q.baseImage, *q.globalBounds)
 	// TODO: Handle errors better (e.g. by wrapping errors and returning them here as well)
 	if err != nil {
 		panic(err)
@@ -234,11 +198,18 @@ func (q *QuadtreeElement) compareImages() float64 {
 	return similarity
 }
 
-// encode writes the quadtree structure to an archive
-func (q *QuadtreeElement) encode(archiveWriter *ArchiveWriter, imagePaths *map[*image.Image]string) (err error) {
+// encode writes the quadtree structure to an archive.
+// pathPrefix is prepended to the paths of all files, allowing several quadtrees in the same archive, and names the plane of the quadtree in references.
+// encodedLeaves maps the blocks encoded so far to references to the leaves holding them.
+// ctx is checked before every node, so that encoding stops early once it is cancelled.
+func (q *QuadtreeElement) encode(ctx context.Context, archiveWriter *ArchiveWriter, encodedLeaves *map[*image.Image]blockReference, pathPrefix string, progress *progressTracker) (err error) {
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+
 	// Create directory path in zip file
 	// TODO: can this be optimized?
-	path := strings.Join(strings.Split(q.id, ""), "/")
+	path := getLeafPath(pathPrefix, q.id)
 
 	// Skip leaves that are out of bounds
 	// Either create and encode an image file if this is a quadtree leaf
@@ -246,18 +217,24 @@ func (q *QuadtreeElement) encode(archiveWriter *ArchiveWriter, imagePaths *map[*
 		// Create temporary buffer that gets passed into archiveWriter later on
 		tempBuffer := new(bytes.Buffer)
 
-		if target, ok := (*imagePaths)[q.blockImageMinimal]; ok {
-			// Write a pseudo symlink if this exact block has already been encoded
-			tempBuffer.Write([]byte(target))
+		if reference, ok := (*encodedLeaves)[q.blockImageMinimal]; ok {
+			// Write a reference if this exact block has already been encoded
+			reference.transform = q.blockTransform
+			tempBuffer.Write(reference.bytes())
+		} else if !q.blockTransform.isIdentity() {
+			// The block this one is transformed from is encoded later, so store the transformed block itself
+			err = encodeBlock(tempBuffer, q.blockTransform.apply(*q.blockImageMinimal), q.config.Encoding)
+			if err != nil {
+				return err
+			}
 		} else {
-			// Encode image as JPEG
-			err = jpeg.Encode(tempBuffer, *q.blockImageMinimal, nil)
+			err = encodeBlock(tempBuffer, *q.blockImageMinimal, q.config.Encoding)
 			if err != nil {
 				return err
 			}
 
-			// Add image path
-			(*imagePaths)[q.blockImageMinimal] = path
+			// Later leaves with the same block refer to this one
+			(*encodedLeaves)[q.blockImageMinimal] = blockReference{plane: pathPrefix, leaf: q.id, transform: identityTransform}
 		}
 
 		// Write temporary buffer to archive
@@ -268,54 +245,37 @@ func (q *QuadtreeElement) encode(archiveWriter *ArchiveWriter, imagePaths *map[*
 		// Or recurse into children
 	} else {
 		for _, child := range q.children {
-			child.encode(archiveWriter, imagePaths)
+			err = child.encode(ctx, archiveWriter, encodedLeaves, pathPrefix, progress)
+			if err != nil {
+				return err
+			}
 		}
 	}
 
+	if len(q.children) == 0 {
+		progress.add(q.getDepth(), 0)
+	}
+
 	return nil
 }
 
 // decode reconstructs the quadtree structure from an archive
-func (q *QuadtreeElement) decode(path string, fileContents *[]byte, remainingHeight int, archiveReader *ArchiveReader) error {
+func (q *QuadtreeElement) decode(path string, fileContents *[]byte, remainingHeight int, leaves *leafReader) error {
 	// If path is empty a leaf has been reached
 	if path == "" {
-		imageBytes := *fileContents
-
-		// Check filetype
-		types, err := filetype.Match(imageBytes)
-		if err != nil {
-			return err
-		}
-
-		// Pseudo symlinks have an undefined filetype
-		if types.MIME.Type == "" && types.MIME.Subtype == "" && types.MIME.Value == "" {
-			// Follow pseudo symlink
-			imagePath := string(imageBytes)
-
-			// TODO: Would allowing multiple symlinks in a row and following them speed up encoding?
-			imageBytesPointer, err := archiveReader.Open(imagePath)
-			if err != nil {
-				return err
-			}
-			imageBytes = *imageBytesPointer
-		}
-
-		// Read real image
-		fileImage, err := utils.ReadImageFromBytes(imageBytes)
+		fileImage, err := leaves.readLeafImage(*fileContents, utils.GetColorModel(q.baseImage))
 		if err != nil {
 			return err
 		}
 
-		fileImageRGBA := image.NewRGBA(fileImage.Bounds())
-		draw.Draw(fileImageRGBA, fileImageRGBA.Bounds(), fileImage, fileImage.Bounds().Min, draw.Src)
-
-		// Duplicate code fragment
 		// Reconstruct blockImage by scaling fileImage up from BlockSize
-		upsamplingInterpolator, err := getInterpolator(q.config.Quadtree.UpsamplingInterpolator)
-		if err != nil {
-			panic(err)
-		}
-		q.blockImage = utils.Scale(fileImageRGBA, q.baseImage.Bounds(), upsamplingInterpolator).(*image.RGBA)
+		blockImage := utils.NewImageLike(fileImage, q.baseImage.Bounds())
+		drawBlock(blockImage, blockImage.Bounds(), fileImage, q.config)
+		q.blockImage = blockImage
+
+		// Keep the minimal block for upsampling passes that take neighbouring leaves into account
+		var blockImageMinimal image.Image = fileImage
+		q.blockImageMinimal = &blockImageMinimal
 
 		return nil
 	}
@@ -330,34 +290,8 @@ func (q *QuadtreeElement) decode(path string, fileContents *[]byte, remainingHei
 	q.decodingMutex.Lock()
 	// If children haven't been created yet, create them
 	if len(q.children) != ChildCount {
-		// TODO: More or less duplicate code fragment
 		for i := 0; i < ChildCount; i++ {
-			var xStart, yStart, xEnd, yEnd int
-
-			// Set x coordinates
-			if i&1 == 0 {
-				// Left block
-				xStart = q.baseImage.Bounds().Min.X
-				xEnd = q.baseImage.Bounds().Min.X + q.baseImage.Bounds().Dx()/2
-			} else {
-				// Right block
-				xStart = q.baseImage.Bounds().Min.X + q.baseImage.Bounds().Dx()/2
-				xEnd = q.baseImage.Bounds().Max.X
-			}
-
-			// Set y coordinates
-			if i&2 == 0 {
-				// Upper block
-				yStart = q.baseImage.Bounds().Min.Y
-				yEnd = q.baseImage.Bounds().Min.Y + q.baseImage.Bounds().Dy()/2
-			} else {
-				// Lower block
-				yStart = q.baseImage.Bounds().Min.Y + q.baseImage.Bounds().Dy()/2
-				yEnd = q.baseImage.Bounds().Max.Y
-			}
-
-			// Copy BaseImage section to sub image
-			childImage := image.NewRGBA(image.Rect(xStart, yStart, xEnd, yEnd))
+			childImage := utils.NewImageLike(q.baseImage, getChildBounds(q.baseImage.Bounds(), i))
 
 			// Create and append child without using NewQuadtreeElement as the block images are irrelevant during decoding
 			child := &QuadtreeElement{
@@ -385,7 +319,7 @@ func (q *QuadtreeElement) decode(path string, fileContents *[]byte, remainingHei
 
 	// Recurse into next child
 	recursePath := strings.Join(splitPath[1:], "/")
-	return q.children[childId].decode(recursePath, fileContents, remainingHeight-1, archiveReader)
+	return q.children[childId].decode(recursePath, fileContents, remainingHeight-1, leaves)
 }
 
 // visualize returns its own blockImage if it has no children, else it returns its childrens blockImages
@@ -404,6 +338,116 @@ func (q *QuadtreeElement) visualize() []VisualizationElement {
 	return visualizations
 }
 
+// getDepth returns the depth of this QuadtreeElement in the quadtree, the root having depth 0
+func (q *QuadtreeElement) getDepth() int {
+	return len(q.id)
+}
+
+// getLeafArea returns the share of the area of treeCount equally weighted quadtrees covered by this QuadtreeElement if it is a leaf, or 0 if it is partitioned further
+func (q *QuadtreeElement) getLeafArea(treeCount int) float64 {
+	if !q.isLeaf {
+		return 0
+	}
+	return getNodeArea(q.getDepth(), treeCount)
+}
+
+// getLeaves returns all leaves of the subtree starting at this QuadtreeElement
+func (q *QuadtreeElement) getLeaves() []*QuadtreeElement {
+	if len(q.children) == 0 {
+		return []*QuadtreeElement{q}
+	}
+
+	leaves := make([]*QuadtreeElement, 0)
+	for _, child := range q.children {
+		leaves = append(leaves, child.getLeaves()...)
+	}
+
+	return leaves
+}
+
+// getChildBounds returns the bounds of the child with index childIndex inside of a parent with bounds parentBounds
+// TODO: this approach probably can't handle cases of ChildCount != 4
+func getChildBounds(parentBounds image.Rectangle, childIndex int) image.Rectangle {
+	var xStart, yStart, xEnd, yEnd int
+
+	// Set x coordinates
+	if childIndex&1 == 0 {
+		// Left block
+		xStart = parentBounds.Min.X
+		xEnd = parentBounds.Min.X + parentBounds.Dx()/2
+	} else {
+		// Right block
+		xStart = parentBounds.Min.X + parentBounds.Dx()/2
+		xEnd = parentBounds.Max.X
+	}
+
+	// Set y coordinates
+	if childIndex&2 == 0 {
+		// Upper block
+		yStart = parentBounds.Min.Y
+		yEnd = parentBounds.Min.Y + parentBounds.Dy()/2
+	} else {
+		// Lower block
+		yStart = parentBounds.Min.Y + parentBounds.Dy()/2
+		yEnd = parentBounds.Max.Y
+	}
+
+	return image.Rect(xStart, yStart, xEnd, yEnd)
+}
+
+// encodeBlock writes a minimal block image to writer in the block format configured in encodingConfig.
+// BlockFormatAuto uses JPEG for 8-bit blocks without transparency, PNG preserves transparency and 16-bit channels.
+func encodeBlock(writer io.Writer, block image.Image, encodingConfig config.EncodingConfig) error {
+	// JPEG stores neither transparency nor more than 8 bits per channel
+	colorModel := utils.GetColorModel(block)
+	isLossySupported := !colorModel.Is16Bit() && (colorModel == utils.ColorModelGray || utils.IsOpaque(block))
+
+	if encodingConfig.BlockFormat != BlockFormatPNG && isLossySupported {
+		var jpegOptions *jpeg.Options
+		if encodingConfig.BlockQuality > 0 {
+			jpegOptions = &jpeg.Options{Quality: encodingConfig.BlockQuality}
+		}
+		return jpeg.Encode(writer, block, jpegOptions)
+	}
+
+	return png.Encode(writer, block)
+}
+
+// decodeBlock decodes the minimal block image stored in the contents of a leaf file into colorModel.
+// Decoding the quadtree and streaming decoding share it, so both produce the same pixels.
+func decodeBlock(fileContents []byte, colorModel utils.ColorModel) (image.Image, error) {
+	fileImage, err := utils.ReadImageFromBytes(fileContents)
+	if err != nil {
+		return nil, err
+	}
+
+	return utils.ConvertImage(fileImage, colorModel), nil
+}
+
+// drawBlock scales a minimal block image up to the bounds of its leaf inside of dst with the upsampling interpolator of cfg.
+// Parts of bounds outside of dst are clipped.
+func drawBlock(dst draw.Image, bounds image.Rectangle, block image.Image, cfg *config.Config) {
+	upsamplingInterpolator, err := getInterpolator(cfg.Quadtree.UpsamplingInterpolator)
+	if err != nil {
+		panic(err)
+	}
+
+	upsamplingInterpolator.Scale(dst, bounds, block, block.Bounds(), drawX.Src, nil)
+}
+
+// getSimilarityMetric returns the metric called metricId from similarityMetrics, an empty metricId selects Weighted
+func getSimilarityMetric(metricId string) (similarityMetric, error) {
+	if metricId == "" {
+		metricId = "Weighted"
+	}
+
+	metric, ok := similarityMetrics[metricId]
+	if !ok {
+		return nil, fmt.Errorf("similarity metric not found: %q", metricId)
+	}
+	return metric, nil
+}
+
 // getInterpolator returns the correct interpolation algorithm for an interpolatorId from interpolators
 func getInterpolator(interpolatorId string) (drawX.Interpolator, error) {
 	interpolator, ok := interpolators[interpolatorId]
@@ -2,6 +2,7 @@ package quadtreeImage
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"image"
 	"image/color"
@@ -9,8 +10,6 @@ import (
 	"io"
 	"math"
 	"math/rand"
-	"strconv"
-	"strings"
 	"sync"
 
 	"github.com/PerformLine/go-stockutil/colorutil"
@@ -24,57 +23,134 @@ type QuadtreeImage struct {
 	baseImage image.Image
 	// Original image with added padding to make it quadratic
 	paddedImage image.Image
+	// Color model all images of the quadtree are processed in
+	colorModel utils.ColorModel
+	// Color space the quadtree is partitioned in
+	colorSpace string
+	// Color space configured for partitioning, which differs from colorSpace if baseImage doesn't support its planes
+	requestedColorSpace string
+	// Independently partitioned quadtrees of the planes of colorSpace, empty if all channels share a single quadtree
+	planes []*QuadtreeImage
+	// Metadata blocks of the original image file that are carried through encoding and decoding
+	imageMetadata *utils.ImageMetadata
 	// Root node of the quadtree
 	root *QuadtreeElement
-	// List of all currently existing quadtree blocks of size BlockSize
-	existingBlocks **[]*image.Image
-	// Regulate access to existingBlocks
-	existingBlocksMutex sync.RWMutex
+	// Minimal blocks of all leaves of the quadtree, used for deduplication
+	blocks *blockIndex
 	// Program configuration
 	config *config.Config
 }
 
-// NewQuadtreeImage constructs a well-formed instance of QuadtreeImage from a baseImage
-func NewQuadtreeImage(baseImage image.Image, cfg *config.Config) *QuadtreeImage {
+// NewQuadtreeImage constructs a well-formed instance of QuadtreeImage from a baseImage.
+// If the configured color space is planar and baseImage supports it, baseImage is split into planes that get their own quadtrees.
+// Otherwise all channels are kept in a single quadtree, and the archive records both the configured and the actual color space.
+// An error is returned if cfg names an unknown color space, interpolator or similarity metric.
+func NewQuadtreeImage(baseImage image.Image, cfg *config.Config) (*QuadtreeImage, error) {
+	space, err := getColorSpace(cfg.Quadtree.ColorSpace)
+	if err != nil {
+		return nil, err
+	}
+
+	downsamplingInterpolator, err := getInterpolator(cfg.Quadtree.DownsamplingInterpolator)
+	if err != nil {
+		return nil, err
+	}
+
+	// The quadtree elements rely on the upsampling interpolator and the similarity metric existing
+	_, err = getInterpolator(cfg.Quadtree.UpsamplingInterpolator)
+	if err != nil {
+		return nil, err
+	}
+	_, err = getSimilarityMetric(cfg.Quadtree.SimilarityMetric)
+	if err != nil {
+		return nil, err
+	}
+
+	qti := newQuadtreeImage(baseImage, cfg)
+	if !space.isPlanar() {
+		return qti, nil
+	}
+
+	qti.requestedColorSpace = cfg.Quadtree.ColorSpace
+	if !supportsPlanes(baseImage) {
+		return qti, nil
+	}
+
+	// Split baseImage into planes and subsample them if necessary
+	qti.colorSpace = cfg.Quadtree.ColorSpace
+	for i, planeImage := range space.split(baseImage) {
+		var subsampledPlaneImage image.Image = planeImage
+		if space.planes[i].subsampling > 1 {
+			subsampledPlaneImage = utils.Scale(planeImage, space.planes[i].getPlaneBounds(planeImage.Bounds()), downsamplingInterpolator)
+		}
+
+		qti.planes = append(qti.planes, newQuadtreeImage(subsampledPlaneImage, getPlaneConfig(cfg, space.planes[i])))
+	}
+
+	return qti, nil
+}
+
+// newQuadtreeImage constructs a QuadtreeImage that keeps all channels of baseImage in a single quadtree
+func newQuadtreeImage(baseImage image.Image, cfg *config.Config) *QuadtreeImage {
 	qti := new(QuadtreeImage)
 
 	qti.config = cfg
 	qti.baseImage = baseImage
+	qti.colorModel = utils.GetColorModel(baseImage)
+	qti.colorSpace = ColorSpaceRGB
+	qti.requestedColorSpace = ColorSpaceRGB
 	qti.paddedImage = qti.pad()
 
-	// Create pointer to shared block list
-	blocks := new([]*image.Image)
-	// Create pointer to pointer so that quadtreeElements can modify the shared list
-	qti.existingBlocks = &blocks
+	// All nodes of the quadtree share the same block index
+	qti.blocks = newBlockIndex()
 
 	return qti
 }
 
-// Partition splits the BaseImage into an appropriate number of sub images and calls their partition method
+// SetImageMetadata sets the EXIF, ICC profile and XMP metadata that is stored alongside the quadtree during encoding
+func (q *QuadtreeImage) SetImageMetadata(imageMetadata *utils.ImageMetadata) {
+	q.imageMetadata = imageMetadata
+}
+
+// Partition splits the BaseImage into an appropriate number of sub images and calls their partition method.
+// Partitioning stops between nodes once ctx is cancelled, returning its error and leaving the quadtree incomplete.
 // TODO: Make this private and call it from Encode. Also rework Encode to work as a static function and handle creating the quadtree in there.
-func (q *QuadtreeImage) Partition() {
-	// Create root of the quadtree
-	rootImage := image.NewRGBA(image.Rect(0, 0, q.paddedImage.Bounds().Max.X, q.paddedImage.Bounds().Max.Y))
-	draw.Draw(rootImage, rootImage.Bounds(), q.paddedImage, q.paddedImage.Bounds().Min, draw.Src)
-	globalBounds := q.baseImage.Bounds()
-	q.root = NewQuadtreeElement("", rootImage, &globalBounds, q.existingBlocks, &q.existingBlocksMutex, q.config)
+func (q *QuadtreeImage) Partition(ctx context.Context) error {
+	progress := newProgressTracker(ctx, StagePartition, 0)
+
+	// Partition every plane on its own
+	trees := q.getTrees()
+	for _, tree := range trees {
+		err := tree.partition(ctx, progress, len(trees))
+		if err != nil {
+			return err
+		}
+	}
 
-	// WaitGroup for use in parallelized partition
-	var wg sync.WaitGroup
+	// Visualize the quadtree of the first plane
+	q.root = trees[0].root
+	return nil
+}
 
-	if q.config.Encoding.Parallelism {
-		wg.Add(1)
+// partition creates the root of a quadtree that keeps all channels in a single tree and partitions it.
+// treeCount is the number of quadtrees sharing progress.
+func (q *QuadtreeImage) partition(ctx context.Context, progress *progressTracker, treeCount int) error {
+	if err := ctx.Err(); err != nil {
+		return err
 	}
 
-	// Start partitioning the quadtree
-	// TODO: Handle this like in decode to avoid passing the waitGroup to partition
-	q.root.partition(&wg)
+	// Create root of the quadtree, all nodes share the pixels of paddedImage
+	globalBounds := q.baseImage.Bounds()
+	q.root = NewQuadtreeElement("", q.paddedImage, &globalBounds, q.blocks, q.config)
+	progress.add(q.root.getDepth(), q.root.getLeafArea(treeCount))
 
-	wg.Wait()
+	// Partition the quadtree with a bounded number of workers
+	return partitionTree(ctx, q.root, getWorkerCount(q.config.Encoding), progress, treeCount)
 }
 
-// Encode encodes a quadtree image into a single buffer and returns it
-func (q *QuadtreeImage) Encode(archiveMode ArchiveMode) (io.Reader, *map[string]io.Reader, error) {
+// Encode encodes a quadtree image into a single buffer and returns it.
+// Encoding stops between nodes once ctx is cancelled, returning its error.
+func (q *QuadtreeImage) Encode(ctx context.Context, archiveMode ArchiveMode) (io.Reader, *map[string]io.Reader, error) {
 	fileBuffer := new(bytes.Buffer)
 	analyticsFiles := make(map[string]io.Reader)
 
@@ -88,17 +164,17 @@ func (q *QuadtreeImage) Encode(archiveMode ArchiveMode) (io.Reader, *map[string]
 		blockVisualizationPadded := q.GetBlockImage(true)
 
 		boxVisualizationBuffer := new(bytes.Buffer)
-		utils.WriteImage(boxVisualization, boxVisualizationBuffer, ".png")
+		utils.WriteImage(boxVisualization, boxVisualizationBuffer, utils.FormatPNG, nil)
 		boxVisualizationPaddedBuffer := new(bytes.Buffer)
-		utils.WriteImage(boxVisualizationPadded, boxVisualizationPaddedBuffer, ".png")
+		utils.WriteImage(boxVisualizationPadded, boxVisualizationPaddedBuffer, utils.FormatPNG, nil)
 		boxGroupVisualizationBuffer := new(bytes.Buffer)
-		utils.WriteImage(boxGroupVisualization, boxGroupVisualizationBuffer, ".png")
+		utils.WriteImage(boxGroupVisualization, boxGroupVisualizationBuffer, utils.FormatPNG, nil)
 		boxGroupVisualizationPaddedBuffer := new(bytes.Buffer)
-		utils.WriteImage(boxGroupVisualizationPadded, boxGroupVisualizationPaddedBuffer, ".png")
+		utils.WriteImage(boxGroupVisualizationPadded, boxGroupVisualizationPaddedBuffer, utils.FormatPNG, nil)
 		blockVisualizationBuffer := new(bytes.Buffer)
-		utils.WriteImage(blockVisualization, blockVisualizationBuffer, ".png")
+		utils.WriteImage(blockVisualization, blockVisualizationBuffer, utils.FormatPNG, nil)
 		blockVisualizationPaddedBuffer := new(bytes.Buffer)
-		utils.WriteImage(blockVisualizationPadded, blockVisualizationPaddedBuffer, ".png")
+		utils.WriteImage(blockVisualizationPadded, blockVisualizationPaddedBuffer, utils.FormatPNG, nil)
 
 		analyticsFiles["encodedBoxVisualization.png"] = boxVisualizationBuffer
 		analyticsFiles["encodedBoxVisualizationPadded.png"] = boxVisualizationPaddedBuffer
@@ -113,14 +189,36 @@ func (q *QuadtreeImage) Encode(archiveMode ArchiveMode) (io.Reader, *map[string]
 		return fileBuffer, &analyticsFiles, err
 	}
 
-	// Keep map of encoded blocks and their path in the archive for deduplication
-	encodedBlockPaths := make(map[*image.Image]string)
+	// Keep map of encoded blocks and the leaves holding them for deduplication
+	encodedLeaves := make(map[*image.Image]blockReference)
+
+	// Every leaf of every quadtree is reported once
+	leafCount := 0
+	for _, tree := range q.getTrees() {
+		leafCount += len(tree.root.getLeaves())
+	}
+	progress := newProgressTracker(ctx, StageEncode, leafCount)
 
 	// TODO: What happens if the first child can already encode the whole picture (e.g. solid color)?
 	// Encode the tree root, which recurses further down the quadtree if needed
-	err = q.root.encode(archiveWriter, &encodedBlockPaths)
-	if err != nil {
-		return fileBuffer, &analyticsFiles, err
+	if len(q.planes) > 0 {
+		// Store the quadtree of every plane in its own directory
+		space, err := getColorSpace(q.colorSpace)
+		if err != nil {
+			return fileBuffer, &analyticsFiles, err
+		}
+
+		for i, planeImage := range q.planes {
+			err = planeImage.root.encode(ctx, archiveWriter, &encodedLeaves, space.planes[i].name, progress)
+			if err != nil {
+				return fileBuffer, &analyticsFiles, err
+			}
+		}
+	} else {
+		err = q.root.encode(ctx, archiveWriter, &encodedLeaves, "", progress)
+		if err != nil {
+			return fileBuffer, &analyticsFiles, err
+		}
 	}
 
 	treeHeight, err := q.getHeight()
@@ -128,16 +226,18 @@ func (q *QuadtreeImage) Encode(archiveMode ArchiveMode) (io.Reader, *map[string]
 		return fileBuffer, &analyticsFiles, err
 	}
 
-	width := q.baseImage.Bounds().Dx()
-	height := q.baseImage.Bounds().Dy()
-
-	// Write metadata
-	metaBuffer := new(bytes.Buffer)
-	metaBuffer.Write([]byte(strconv.Itoa(treeHeight) + "\n" +
-		strconv.Itoa(width) + "\n" +
-		strconv.Itoa(height)))
+	meta := &metadata{
+		treeHeight:          treeHeight,
+		width:               q.baseImage.Bounds().Dx(),
+		height:              q.baseImage.Bounds().Dy(),
+		hasAlpha:            !utils.IsOpaque(q.baseImage),
+		colorModel:          q.colorModel,
+		colorSpace:          q.colorSpace,
+		requestedColorSpace: q.requestedColorSpace,
+		hasReferenceRecords: true,
+	}
 
-	err = archiveWriter.WriteFile(MetaFile, metaBuffer)
+	err = writeMetadataFiles(archiveWriter, meta, q.imageMetadata)
 	if err != nil {
 		return fileBuffer, &analyticsFiles, err
 	}
@@ -147,51 +247,186 @@ func (q *QuadtreeImage) Encode(archiveMode ArchiveMode) (io.Reader, *map[string]
 	return fileBuffer, &analyticsFiles, err
 }
 
-// Decode decodes an encoded quadtree image and populates a quadtree with it
-func Decode(quadtreePath string, outputPath string, cfg *config.Config) (io.Reader, *map[string]io.Reader, error) {
+// writeMetadataFiles writes meta and the metadata blocks of the original image file to archiveWriter, after all leaves have been written
+func writeMetadataFiles(archiveWriter *ArchiveWriter, meta *metadata, imageMetadata *utils.ImageMetadata) error {
+	// Write metadata
+	err := archiveWriter.WriteFile(MetaFile, meta.reader())
+	if err != nil {
+		return err
+	}
+
+	// Write metadata blocks of the original image file
+	if !imageMetadata.IsEmpty() {
+		for filename, contents := range map[string][]byte{
+			ExifFile:       imageMetadata.Exif,
+			ICCProfileFile: imageMetadata.ICCProfile,
+			XMPFile:        imageMetadata.XMP,
+		} {
+			if len(contents) > 0 {
+				err = archiveWriter.WriteFile(filename, bytes.NewReader(contents))
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// Decode decodes an encoded quadtree image and populates a quadtree with it.
+// Decoding stops between leaves once ctx is cancelled, returning its error.
+func Decode(ctx context.Context, quadtreePath string, outputPath string, cfg *config.Config) (io.Reader, *map[string]io.Reader, error) {
 	analyticsFiles := make(map[string]io.Reader)
 
+	// Paint leaves while reading the archive if nothing needs the whole quadtree
+	if cfg.Decoding.Streaming.Enable && !cfg.Decoding.BoundaryAwareUpsampling.Enable && !cfg.VisualizationConfig.Enable {
+		decoded, err := decodeStreaming(ctx, quadtreePath, outputPath, cfg)
+		return decoded, &analyticsFiles, err
+	}
+
 	archiveReader, err := OpenArchiveReader(quadtreePath)
 	if err != nil {
 		return nil, &analyticsFiles, err
 	}
 
 	// Parse metadata
-	metaBytes, err := archiveReader.Open(MetaFile)
+	meta, err := readMetadata(archiveReader)
 	if err != nil {
 		return nil, &analyticsFiles, err
 	}
 
-	meta := strings.Split(string(*metaBytes), "\n")
-	if len(meta) != 3 {
-		return nil, &analyticsFiles, fmt.Errorf("meta file contained %d newline-seperated values instead of three", len(meta))
+	leaves := newLeafReader(archiveReader, meta)
+	baseImage := utils.NewImage(meta.colorModel, image.Rect(0, 0, meta.width, meta.height))
+
+	// Every file of the archive besides the reserved ones is a leaf of one of the quadtrees
+	leafCount := 0
+	for filename := range archiveReader.Files() {
+		if !isReservedFile(filename) {
+			leafCount++
+		}
 	}
+	progress := newProgressTracker(ctx, StageDecode, leafCount)
+
+	// Create QuadtreeImage
+	qti := newQuadtreeImage(baseImage, cfg)
 
-	treeHeight, err := strconv.Atoi(meta[0])
+	space, err := getColorSpace(meta.colorSpace)
 	if err != nil {
 		return nil, &analyticsFiles, err
 	}
 
-	width, err := strconv.Atoi(meta[1])
+	if space.isPlanar() {
+		// Decode the quadtree of every plane from its own directory
+		qti.colorSpace = meta.colorSpace
+		for _, p := range space.planes {
+			planeImage := newQuadtreeImage(image.NewGray(p.getPlaneBounds(baseImage.Bounds())), getPlaneConfig(cfg, p))
+
+			planeHeight, err := planeImage.getHeight()
+			if err != nil {
+				return nil, &analyticsFiles, err
+			}
+
+			err = planeImage.decodeTree(ctx, leaves, p.name, planeHeight, progress)
+			if err != nil {
+				return nil, &analyticsFiles, err
+			}
+
+			qti.planes = append(qti.planes, planeImage)
+		}
+
+		// Visualize the quadtree of the first plane
+		qti.root = qti.planes[0].root
+	} else {
+		err = qti.decodeTree(ctx, leaves, "", meta.treeHeight, progress)
+		if err != nil {
+			return nil, &analyticsFiles, err
+		}
+	}
+
+	// Interpolate across leaf boundaries instead of upsampling every leaf in isolation
+	if qti.config.Decoding.BoundaryAwareUpsampling.Enable {
+		for _, tree := range qti.getTrees() {
+			err = tree.upsampleBoundaryAware()
+			if err != nil {
+				return nil, &analyticsFiles, err
+			}
+		}
+	}
+
+	if qti.config.VisualizationConfig.Enable {
+		boxVisualization, _ := qti.GetBoxImage(false, false, nil)
+		boxVisualizationPadded, _ := qti.GetBoxImage(true, false, nil)
+		boxGroupVisualization, palette := qti.GetBoxImage(false, true, nil)
+		boxGroupVisualizationPadded, _ := qti.GetBoxImage(true, true, palette)
+		blockVisualization := qti.GetBlockImage(false)
+		blockVisualizationPadded := qti.GetBlockImage(true)
+
+		boxVisualizationBuffer := new(bytes.Buffer)
+		utils.WriteImage(boxVisualization, boxVisualizationBuffer, utils.FormatPNG, nil)
+		boxVisualizationPaddedBuffer := new(bytes.Buffer)
+		utils.WriteImage(boxVisualizationPadded, boxVisualizationPaddedBuffer, utils.FormatPNG, nil)
+		boxGroupVisualizationBuffer := new(bytes.Buffer)
+		utils.WriteImage(boxGroupVisualization, boxGroupVisualizationBuffer, utils.FormatPNG, nil)
+		boxGroupVisualizationPaddedBuffer := new(bytes.Buffer)
+		utils.WriteImage(boxGroupVisualizationPadded, boxGroupVisualizationPaddedBuffer, utils.FormatPNG, nil)
+		blockVisualizationBuffer := new(bytes.Buffer)
+		utils.WriteImage(blockVisualization, blockVisualizationBuffer, utils.FormatPNG, nil)
+		blockVisualizationPaddedBuffer := new(bytes.Buffer)
+		utils.WriteImage(blockVisualizationPadded, blockVisualizationPaddedBuffer, utils.FormatPNG, nil)
+
+		analyticsFiles["decodedBoxVisualization.png"] = boxVisualizationBuffer
+		analyticsFiles["decodedBoxVisualizationPadded.png"] = boxVisualizationPaddedBuffer
+		analyticsFiles["decodedBoxGroupVisualization.png"] = boxGroupVisualizationBuffer
+		analyticsFiles["decodedBoxGroupVisualizationPadded.png"] = boxGroupVisualizationPaddedBuffer
+		analyticsFiles["decodedBlockVisualization.png"] = blockVisualizationBuffer
+		analyticsFiles["decodedBlockVisualizationPadded.png"] = blockVisualizationPaddedBuffer
+	}
+
+	decodedImage, err := qti.getDecodedImage()
 	if err != nil {
 		return nil, &analyticsFiles, err
 	}
 
-	height, err := strconv.Atoi(meta[2])
+	decoded, err := writeDecodedImage(decodedImage, outputPath, readImageMetadata(archiveReader), cfg)
+	return decoded, &analyticsFiles, err
+}
+
+// writeDecodedImage encodes decodedImage in the output format for outputPath and re-emits the metadata blocks of the original image file
+func writeDecodedImage(decodedImage image.Image, outputPath string, imageMetadata *utils.ImageMetadata, cfg *config.Config) (io.Reader, error) {
+	format, err := GetOutputFormat(cfg, outputPath)
 	if err != nil {
-		return nil, &analyticsFiles, err
+		return nil, err
 	}
 
-	baseImage := image.NewRGBA(image.Rect(0, 0, width, height))
+	writeOptions, err := GetWriteOptions(cfg)
+	if err != nil {
+		return nil, err
+	}
 
-	// Create QuadtreeImage
-	qti := NewQuadtreeImage(baseImage, cfg)
+	fileBuffer := new(bytes.Buffer)
+	err = utils.WriteImage(decodedImage, fileBuffer, format, writeOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	// Re-emit metadata blocks of the original image file
+	fileBytes, err := utils.WriteImageMetadata(fileBuffer.Bytes(), imageMetadata)
+	if err != nil {
+		return nil, err
+	}
 
+	return bytes.NewReader(fileBytes), nil
+}
+
+// decodeTree creates the root of the quadtree and populates it with all files of archiveReader that are located in the directory pathPrefix.
+// No further files are decoded once ctx is cancelled.
+func (q *QuadtreeImage) decodeTree(ctx context.Context, leaves *leafReader, pathPrefix string, treeHeight int, progress *progressTracker) error {
 	// Create root manually to avoid calling its partition method
-	qti.root = &QuadtreeElement{
+	q.root = &QuadtreeElement{
 		id:        "",
-		config:    cfg,
-		baseImage: qti.paddedImage,
+		config:    q.config,
+		baseImage: q.paddedImage,
 	}
 
 	var errorMap map[string]error = make(map[string]error)
@@ -199,25 +434,39 @@ func Decode(quadtreePath string, outputPath string, cfg *config.Config) (io.Read
 	var mapWriteMutex sync.Mutex
 
 	// Iterate over archive contents and decode them
-	for fn, fc := range archiveReader.Files() {
+	for fn, fc := range leaves.archiveReader.Files() {
+		if ctx.Err() != nil {
+			break
+		}
 
-		// Copy filename and contents to avoid memory corruption on parallelized runs
-		filename := fn
-		fileContents := make([]byte, len(*fc))
-		copy(fileContents, *fc)
+		// Skip metadata files
+		if isReservedFile(fn) {
+			continue
+		}
 
-		// Skip metadata file
-		if filename == MetaFile {
+		// Skip files of other quadtrees
+		treePath, ok := trimPath(pathPrefix, fn)
+		if !ok {
 			continue
 		}
 
+		// Copy loop variables for parallelized runs, the cached contents are only read
+		filename := fn
+		fileContents := fc
+
 		// Decode file into quadtree
-		if qti.config.Decoding.Parallelism {
+		if q.config.Decoding.Parallelism {
 			wg.Add(1)
 			go func() {
 				defer wg.Done()
 
-				err = qti.root.decode(filename, &fileContents, treeHeight, archiveReader)
+				// Skip files that haven't been decoded before cancellation
+				if ctx.Err() != nil {
+					return
+				}
+
+				err := q.root.decode(treePath, fileContents, treeHeight, leaves)
+				progress.add(getPathDepth(treePath), 0)
 
 				// Write result to errorMap
 				mapWriteMutex.Lock()
@@ -225,57 +474,111 @@ func Decode(quadtreePath string, outputPath string, cfg *config.Config) (io.Read
 				mapWriteMutex.Unlock()
 			}()
 		} else {
-			errorMap[filename] = qti.root.decode(filename, &fileContents, treeHeight, archiveReader)
+			errorMap[filename] = q.root.decode(treePath, fileContents, treeHeight, leaves)
+			progress.add(getPathDepth(treePath), 0)
 		}
 	}
 
 	wg.Wait()
 
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
 	// Return first error found in errorMap, if any
 	for _, e := range errorMap {
 		if e != nil {
-			return nil, &analyticsFiles, e
+			return e
 		}
 	}
 
-	if qti.config.VisualizationConfig.Enable {
-		boxVisualization, _ := qti.GetBoxImage(false, false, nil)
-		boxVisualizationPadded, _ := qti.GetBoxImage(true, false, nil)
-		boxGroupVisualization, palette := qti.GetBoxImage(false, true, nil)
-		boxGroupVisualizationPadded, _ := qti.GetBoxImage(true, true, palette)
-		blockVisualization := qti.GetBlockImage(false)
-		blockVisualizationPadded := qti.GetBlockImage(true)
+	return nil
+}
 
-		boxVisualizationBuffer := new(bytes.Buffer)
-		utils.WriteImage(boxVisualization, boxVisualizationBuffer, ".png")
-		boxVisualizationPaddedBuffer := new(bytes.Buffer)
-		utils.WriteImage(boxVisualizationPadded, boxVisualizationPaddedBuffer, ".png")
-		boxGroupVisualizationBuffer := new(bytes.Buffer)
-		utils.WriteImage(boxGroupVisualization, boxGroupVisualizationBuffer, ".png")
-		boxGroupVisualizationPaddedBuffer := new(bytes.Buffer)
-		utils.WriteImage(boxGroupVisualizationPadded, boxGroupVisualizationPaddedBuffer, ".png")
-		blockVisualizationBuffer := new(bytes.Buffer)
-		utils.WriteImage(blockVisualization, blockVisualizationBuffer, ".png")
-		blockVisualizationPaddedBuffer := new(bytes.Buffer)
-		utils.WriteImage(blockVisualizationPadded, blockVisualizationPaddedBuffer, ".png")
+// getTrees returns the QuadtreeImages of all planes, or the QuadtreeImage itself if all channels share a single quadtree
+func (q *QuadtreeImage) getTrees() []*QuadtreeImage {
+	if len(q.planes) > 0 {
+		return q.planes
+	}
+	return []*QuadtreeImage{q}
+}
 
-		analyticsFiles["decodedBoxVisualization.png"] = boxVisualizationBuffer
-		analyticsFiles["decodedBoxVisualizationPadded.png"] = boxVisualizationPaddedBuffer
-		analyticsFiles["decodedBoxGroupVisualization.png"] = boxGroupVisualizationBuffer
-		analyticsFiles["decodedBoxGroupVisualizationPadded.png"] = boxGroupVisualizationPaddedBuffer
-		analyticsFiles["decodedBlockVisualization.png"] = blockVisualizationBuffer
-		analyticsFiles["decodedBlockVisualizationPadded.png"] = blockVisualizationPaddedBuffer
+// getDecodedImage returns the image encoded in the quadtree with all enabled decoding filters applied
+func (q *QuadtreeImage) getDecodedImage() (draw.Image, error) {
+	if len(q.planes) == 0 {
+		decodedImage := q.GetBlockImage(false)
+
+		// Smooth seams between leaves
+		if q.config.Decoding.Deblocking.Enable {
+			q.deblock(decodedImage)
+		}
+
+		return decodedImage, nil
 	}
 
-	fileBuffer := new(bytes.Buffer)
-	utils.WriteImage(qti.GetBlockImage(false), fileBuffer, ".png")
+	planeImages := make([]image.Image, 0, len(q.planes))
+	for _, planeImage := range q.planes {
+		decodedPlaneImage, err := planeImage.getDecodedImage()
+		if err != nil {
+			return nil, err
+		}
+		planeImages = append(planeImages, decodedPlaneImage)
+	}
+
+	return q.mergePlanes(planeImages, q.baseImage.Bounds())
+}
+
+// mergePlanes scales the images of all planes to bounds and merges them into a single image according to the color space
+func (q *QuadtreeImage) mergePlanes(planeImages []image.Image, bounds image.Rectangle) (draw.Image, error) {
+	return mergePlaneImages(planeImages, bounds, q.colorSpace, q.config)
+}
+
+// mergePlaneImages scales the images of all planes of the color space colorSpaceId to bounds and merges them into a single image
+func mergePlaneImages(planeImages []image.Image, bounds image.Rectangle, colorSpaceId string, cfg *config.Config) (draw.Image, error) {
+	space, err := getColorSpace(colorSpaceId)
+	if err != nil {
+		return nil, err
+	}
+
+	upsamplingInterpolator, err := getInterpolator(cfg.Quadtree.UpsamplingInterpolator)
+	if err != nil {
+		return nil, err
+	}
+
+	// Bring subsampled planes back to full resolution
+	scaledPlaneImages := make([]image.Image, 0, len(planeImages))
+	for _, planeImage := range planeImages {
+		if !planeImage.Bounds().Eq(bounds) {
+			planeImage = utils.Scale(planeImage, bounds, upsamplingInterpolator)
+		}
+		scaledPlaneImages = append(scaledPlaneImages, planeImage)
+	}
 
-	return fileBuffer, &analyticsFiles, nil
+	return space.merge(scaledPlaneImages), nil
 }
 
 // GetBlockImage creates a representation of the image encoded in the quadtree.
 // If padded is true, the padding area around the original image is included as well.
-func (q *QuadtreeImage) GetBlockImage(padded bool) image.Image {
+func (q *QuadtreeImage) GetBlockImage(padded bool) draw.Image {
+	// Merge the block images of all planes
+	if len(q.planes) > 0 {
+		planeImages := make([]image.Image, 0, len(q.planes))
+		for _, planeImage := range q.planes {
+			planeImages = append(planeImages, planeImage.GetBlockImage(padded))
+		}
+
+		bounds := q.baseImage.Bounds()
+		if padded {
+			bounds = q.paddedImage.Bounds()
+		}
+
+		blockImage, err := q.mergePlanes(planeImages, image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+		if err != nil {
+			panic(err)
+		}
+		return blockImage
+	}
+
 	visualizations := q.root.visualize()
 
 	// Choose correct inputImage
@@ -287,7 +590,7 @@ func (q *QuadtreeImage) GetBlockImage(padded bool) image.Image {
 	}
 
 	// Setup bounds of blockImage
-	blockImage := image.NewRGBA(image.Rect(0, 0, inputBounds.Dx(), inputBounds.Dy()))
+	blockImage := utils.NewImage(q.colorModel, image.Rect(0, 0, inputBounds.Dx(), inputBounds.Dy()))
 
 	// Draw blocks of quadtree leaves onto blockimage
 	for _, visualization := range visualizations {
@@ -379,24 +682,10 @@ func (q *QuadtreeImage) GetBoxImage(padded bool, deduplicated bool, palette map[
 
 // pad adds transparent padding to a copy of BaseImage to make it a square with an edge length that can be divided by a multiple of four to get a JPEG block
 func (q *QuadtreeImage) pad() image.Image {
-	baseBounds := q.baseImage.Bounds()
-	var longerSideLength int
-	paddedSideLength := BlockSize
-
-	// Find the longer side of X and Y
-	if baseBounds.Dx() > baseBounds.Dy() {
-		longerSideLength = baseBounds.Dx()
-	} else {
-		longerSideLength = baseBounds.Dy()
-	}
-
-	// Pad until the padding is greater than both sides of the BaseImage
-	for paddedSideLength < longerSideLength {
-		paddedSideLength *= 2
-	}
+	paddedSideLength := getPaddedSideLength(q.baseImage.Bounds())
 
 	// Copy BaseImage over padded image
-	paddedImage := image.NewRGBA(image.Rect(0, 0, paddedSideLength, paddedSideLength))
+	paddedImage := utils.NewImage(q.colorModel, image.Rect(0, 0, paddedSideLength, paddedSideLength))
 	draw.Draw(paddedImage, paddedImage.Bounds(), q.baseImage, q.baseImage.Bounds().Min, draw.Src)
 
 	utils.FillSpace(paddedImage, q.baseImage.Bounds())
@@ -414,8 +703,33 @@ func (q *QuadtreeImage) getHeight() (int, error) {
 		return 0, fmt.Errorf("padded image is not quadratic (width: %d, height: %d)", dx, dy)
 	}
 
+	return getTreeHeight(dx), nil
+}
+
+// getPaddedSideLength returns the edge length of the smallest square that covers bounds and can be partitioned down to blocks of size BlockSize
+func getPaddedSideLength(bounds image.Rectangle) int {
+	var longerSideLength int
+	paddedSideLength := BlockSize
+
+	// Find the longer side of X and Y
+	if bounds.Dx() > bounds.Dy() {
+		longerSideLength = bounds.Dx()
+	} else {
+		longerSideLength = bounds.Dy()
+	}
+
+	// Pad until the padding is greater than both sides of bounds
+	for paddedSideLength < longerSideLength {
+		paddedSideLength *= 2
+	}
+
+	return paddedSideLength
+}
+
+// getTreeHeight returns how high a quadtree covering a square with edge length paddedSideLength would need to be to have children of size BlockSize as leaves
+func getTreeHeight(paddedSideLength int) int {
 	// How many blocks would the tree be made up of in the worst case?
-	blockCount := float64(dx) / float64(BlockSize)
+	blockCount := float64(paddedSideLength) / float64(BlockSize)
 	// How often would the tree need to partition to get to blocks of size BlockSize?
-	return int(math.Log2(blockCount)), nil
+	return int(math.Log2(blockCount))
 }
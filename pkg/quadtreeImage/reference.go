@@ -0,0 +1,294 @@
+package quadtreeImage
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"strconv"
+	"strings"
+
+	"github.com/h2non/filetype"
+	"github.com/xaverhimmelsbach/quadtree-block-compression/pkg/utils"
+)
+
+const (
+	// referenceHeader is the first line of every reference record, block images can't start with it
+	referenceHeader = "quadtree-reference"
+	// referenceKeyPlane holds the name of the plane whose quadtree holds the referenced leaf, it is left out for archives of a single quadtree
+	referenceKeyPlane = "plane"
+	// referenceKeyLeaf holds the identifier of the referenced leaf, the indices of the children on the way to it from the root of its quadtree
+	referenceKeyLeaf = "leaf"
+	// referenceKeyOrientation holds the EXIF orientation the block of the referenced leaf is reoriented with
+	referenceKeyOrientation = "orientation"
+	// referenceKeyOffset holds the value added to every color channel of the block of the referenced leaf
+	referenceKeyOffset = "offset"
+)
+
+// blockReference is a leaf file that refers to the block of another leaf instead of holding a block image.
+// Reference records start with referenceHeader, followed by key=value lines identifying the target leaf and the transform applied to its block.
+// Targets are identified by their plane and leaf identifier, independent of how leaves are stored in the archive, and mapped to the path of their file when reading.
+// The target may be a reference itself, chains of references are followed until a leaf holding a block image.
+type blockReference struct {
+	// Name of the plane holding the referenced leaf, empty for archives of a single quadtree
+	plane string
+	// Identifier of the referenced leaf within the quadtree of its plane
+	leaf      string
+	transform blockTransform
+}
+
+// getTarget returns the path of the file of the referenced leaf inside of the archive
+func (r blockReference) getTarget() string {
+	return getLeafPath(r.plane, r.leaf)
+}
+
+// isBlockReference returns whether the contents of a leaf file are a reference record
+func isBlockReference(fileContents []byte) bool {
+	header := []byte(referenceHeader)
+	return bytes.HasPrefix(fileContents, header) && (len(fileContents) == len(header) || fileContents[len(header)] == '\n')
+}
+
+// parseBlockReference parses a reference record
+func parseBlockReference(fileContents []byte) (blockReference, error) {
+	reference := blockReference{transform: identityTransform}
+
+	// The root of a quadtree has an empty identifier, so a missing leaf is told apart by its key
+	lines := strings.Split(string(fileContents), "\n")
+	hasLeaf, err := reference.parseFields(lines[1:])
+	if err != nil {
+		return reference, err
+	}
+
+	if !hasLeaf {
+		return reference, fmt.Errorf("reference has no %s", referenceKeyLeaf)
+	}
+
+	return reference, nil
+}
+
+// parseFields parses the key=value lines of a reference and returns whether they hold the leaf it refers to
+func (r *blockReference) parseFields(lines []string) (bool, error) {
+	hasLeaf := false
+	for _, line := range lines {
+		if line == "" {
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return false, fmt.Errorf("reference has a malformed line %q", line)
+		}
+
+		switch key {
+		case referenceKeyPlane:
+			r.plane = value
+		case referenceKeyLeaf:
+			if !isLeafIdentifier(value) {
+				return false, fmt.Errorf("reference has an invalid %s %q", referenceKeyLeaf, value)
+			}
+			r.leaf = value
+			hasLeaf = true
+		case referenceKeyOrientation:
+			number, err := strconv.Atoi(value)
+			if err != nil || number < 1 || number > 8 {
+				return false, fmt.Errorf("reference to %s has an invalid orientation %q", r.getTarget(), value)
+			}
+			r.transform.orientation = number
+		case referenceKeyOffset:
+			number, err := strconv.Atoi(value)
+			if err != nil || number < -0xff || number > 0xff {
+				return false, fmt.Errorf("reference to %s has an invalid offset %q", r.getTarget(), value)
+			}
+			r.transform.offset = number
+		default:
+			return false, fmt.Errorf("reference to %s has an unknown key %q", r.getTarget(), key)
+		}
+	}
+
+	return hasLeaf, nil
+}
+
+// bytes returns the reference record of r, identity transforms are left out
+func (r blockReference) bytes() []byte {
+	buffer := bytes.NewBufferString(referenceHeader)
+	if r.plane != "" {
+		fmt.Fprintf(buffer, "\n%s=%s", referenceKeyPlane, r.plane)
+	}
+	fmt.Fprintf(buffer, "\n%s=%s", referenceKeyLeaf, r.leaf)
+	if r.transform.orientation > 1 {
+		fmt.Fprintf(buffer, "\n%s=%d", referenceKeyOrientation, r.transform.orientation)
+	}
+	if r.transform.offset != 0 {
+		fmt.Fprintf(buffer, "\n%s=%d", referenceKeyOffset, r.transform.offset)
+	}
+	return buffer.Bytes()
+}
+
+// isLegacyReference returns whether the contents of a leaf file of an archive without reference records are a pseudo symlink.
+// Pseudo symlinks hold the path of their target instead of a block image and are only recognised by having no known file type.
+func isLegacyReference(fileContents []byte) (bool, error) {
+	types, err := filetype.Match(fileContents)
+	if err != nil {
+		return false, err
+	}
+
+	return types.MIME.Type == "" && types.MIME.Subtype == "" && types.MIME.Value == "", nil
+}
+
+// parseLegacyReference parses a pseudo symlink, which holds nothing but the path of its target.
+// Pseudo symlinks were written before transforms existed, so they always refer to the block as it is.
+func parseLegacyReference(fileContents []byte) blockReference {
+	// The trailing directories holding a single child index make up the leaf identifier, anything before them names the plane
+	components := strings.Split(string(fileContents), "/")
+	leafStart := len(components)
+	for leafStart > 0 && len(components[leafStart-1]) == 1 && isLeafIdentifier(components[leafStart-1]) {
+		leafStart--
+	}
+
+	return blockReference{
+		plane:     strings.Join(components[:leafStart], "/"),
+		leaf:      strings.Join(components[leafStart:], ""),
+		transform: identityTransform,
+	}
+}
+
+// isLeafIdentifier returns whether id consists of child indices only
+func isLeafIdentifier(id string) bool {
+	for _, index := range id {
+		if index < '0' || index >= '0'+ChildCount {
+			return false
+		}
+	}
+	return true
+}
+
+// parseLeafFile returns the reference stored in the contents of a leaf file and whether it holds one instead of a block image.
+// legacyReferences selects pseudo symlinks instead of reference records for archives written before reference records existed.
+func parseLeafFile(fileContents []byte, legacyReferences bool) (blockReference, bool, error) {
+	if !legacyReferences {
+		if !isBlockReference(fileContents) {
+			return blockReference{}, false, nil
+		}
+
+		reference, err := parseBlockReference(fileContents)
+		return reference, true, err
+	}
+
+	isReference, err := isLegacyReference(fileContents)
+	if err != nil || !isReference {
+		return blockReference{}, false, err
+	}
+
+	return parseLegacyReference(fileContents), true, nil
+}
+
+// resolveReference follows reference and all references it leads to until a leaf holding a block image.
+// lookup returns the reference stored in a leaf file and whether it holds one.
+// The filename of the leaf holding the block image is returned together with the transforms of all references on the way, the last one is applied first.
+func resolveReference(reference blockReference, lookup func(filename string) (blockReference, bool, error)) (string, []blockTransform, error) {
+	transforms := []blockTransform{reference.transform}
+	visited := make(map[string]bool)
+
+	target := reference.getTarget()
+	for {
+		if isReservedFile(target) {
+			return "", nil, fmt.Errorf("reference to reserved file %s", target)
+		}
+		if visited[target] {
+			return "", nil, fmt.Errorf("reference to %s is part of a cycle", target)
+		}
+		visited[target] = true
+
+		next, isReference, err := lookup(target)
+		if err != nil {
+			return "", nil, fmt.Errorf("reference to %s: %w", target, err)
+		}
+		if !isReference {
+			return target, transforms, nil
+		}
+
+		transforms = append(transforms, next.transform)
+		target = next.getTarget()
+	}
+}
+
+// applyTransforms applies the transforms returned by resolveReference to the block of the resolved leaf
+func applyTransforms(block image.Image, transforms []blockTransform) image.Image {
+	for i := len(transforms) - 1; i >= 0; i-- {
+		block = transforms[i].apply(block)
+	}
+	return block
+}
+
+// leafReader reads the leaf files of an archive, following the references between them
+type leafReader struct {
+	archiveReader *ArchiveReader
+	// Does the archive store references as pseudo symlinks instead of reference records?
+	legacyReferences bool
+}
+
+// newLeafReader returns a leafReader for the leaf files of archiveReader, whose MetaFile has been parsed into meta
+func newLeafReader(archiveReader *ArchiveReader, meta *metadata) *leafReader {
+	return &leafReader{archiveReader: archiveReader, legacyReferences: !meta.hasReferenceRecords}
+}
+
+// readReference returns the reference stored in the leaf file filename and whether it holds one
+func (l *leafReader) readReference(filename string) (blockReference, bool, error) {
+	fileContents, err := l.archiveReader.Open(filename)
+	if err != nil {
+		return blockReference{}, false, err
+	}
+
+	return parseLeafFile(*fileContents, l.legacyReferences)
+}
+
+// readBlockFile follows the references stored in the contents of a leaf file and returns the contents of the leaf holding its block image.
+// The transforms turning the stored block into the block of the leaf are returned as well.
+func (l *leafReader) readBlockFile(fileContents []byte) ([]byte, []blockTransform, error) {
+	reference, isReference, err := parseLeafFile(fileContents, l.legacyReferences)
+	if err != nil || !isReference {
+		return fileContents, nil, err
+	}
+
+	target, transforms, err := resolveReference(reference, l.readReference)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	targetContents, err := l.archiveReader.Open(target)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return *targetContents, transforms, nil
+}
+
+// readBlock returns the block image stored in the contents of a leaf file, following references.
+// The block is returned as stored together with the transforms turning it into the block of the leaf.
+func (l *leafReader) readBlock(fileContents []byte) (image.Image, []blockTransform, error) {
+	blockContents, transforms, err := l.readBlockFile(fileContents)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	blockImage, err := utils.ReadImageFromBytes(blockContents)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return blockImage, transforms, nil
+}
+
+// readLeafImage decodes the block image of the contents of a leaf file into colorModel, following references and applying their transforms
+func (l *leafReader) readLeafImage(fileContents []byte, colorModel utils.ColorModel) (image.Image, error) {
+	blockContents, transforms, err := l.readBlockFile(fileContents)
+	if err != nil {
+		return nil, err
+	}
+
+	blockImage, err := decodeBlock(blockContents, colorModel)
+	if err != nil {
+		return nil, err
+	}
+
+	return applyTransforms(blockImage, transforms), nil
+}
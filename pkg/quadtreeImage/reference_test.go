@@ -0,0 +1,210 @@
+package quadtreeImage
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"io/fs"
+	"testing"
+	"time"
+
+	"github.com/xaverhimmelsbach/quadtree-block-compression/pkg/config"
+	"github.com/xaverhimmelsbach/quadtree-block-compression/pkg/utils"
+)
+
+// newTestLeafReader returns a leafReader for an archive holding files, which stores references as reference records
+func newTestLeafReader(files map[string][]byte) *leafReader {
+	fileCache := make(map[string]*[]byte)
+	for filename := range files {
+		fileContents := files[filename]
+		fileCache[filename] = &fileContents
+	}
+
+	return &leafReader{archiveReader: &ArchiveReader{fileCache: fileCache}}
+}
+
+// encodeTestBlock returns the PNG file of a block of a single color
+func encodeTestBlock(t *testing.T, c color.Color) []byte {
+	block := image.NewRGBA(image.Rect(0, 0, BlockSize, BlockSize))
+	for i := 0; i < BlockSize*BlockSize; i++ {
+		block.Set(i%BlockSize, i/BlockSize, c)
+	}
+
+	buffer := new(bytes.Buffer)
+	if err := png.Encode(buffer, block); err != nil {
+		t.Fatal(err)
+	}
+	return buffer.Bytes()
+}
+
+// readBlockFileWithTimeout reads the leaf file filename with leaves and fails if following its references doesn't terminate
+func readBlockFileWithTimeout(t *testing.T, leaves *leafReader, filename string) ([]byte, []blockTransform, error) {
+	type result struct {
+		blockContents []byte
+		transforms    []blockTransform
+		err           error
+	}
+
+	done := make(chan result)
+	go func() {
+		fileContents, err := leaves.archiveReader.Open(filename)
+		if err != nil {
+			done <- result{err: err}
+			return
+		}
+		blockContents, transforms, err := leaves.readBlockFile(*fileContents)
+		done <- result{blockContents, transforms, err}
+	}()
+
+	select {
+	case r := <-done:
+		return r.blockContents, r.transforms, r.err
+	case <-time.After(10 * time.Second):
+		t.Fatalf("following the references of %s didn't terminate", filename)
+		return nil, nil, nil
+	}
+}
+
+func TestBlockReferenceRoundTrip(t *testing.T) {
+	references := []blockReference{
+		{leaf: "0213", transform: identityTransform},
+		{plane: "Cb", leaf: "30", transform: blockTransform{orientation: 6, offset: -12}},
+		// The root of a quadtree has an empty identifier
+		{plane: "Y", leaf: "", transform: blockTransform{orientation: 1, offset: 3}},
+	}
+
+	for _, want := range references {
+		record := want.bytes()
+		if !isBlockReference(record) {
+			t.Fatalf("%q isn't recognised as reference record", record)
+		}
+
+		got, err := parseBlockReference(record)
+		if err != nil {
+			t.Fatalf("%q: %v", record, err)
+		}
+		if got != want {
+			t.Errorf("%q was parsed as %+v, want %+v", record, got, want)
+		}
+	}
+
+	for _, record := range []string{"quadtree-reference", "quadtree-reference\nplane=Y", "quadtree-reference\nleaf=0/1", "quadtree-reference\nleaf=04", "quadtree-reference\nleaf=0\norientation=9"} {
+		if _, err := parseBlockReference([]byte(record)); err == nil {
+			t.Errorf("invalid record %q was parsed", record)
+		}
+	}
+}
+
+func TestReferenceChainsAreFollowed(t *testing.T) {
+	// a refers to b, which refers to c holding the block image
+	leaves := newTestLeafReader(map[string][]byte{
+		"Y/0": blockReference{plane: "Y", leaf: "1", transform: blockTransform{orientation: 6}}.bytes(),
+		"Y/1": blockReference{plane: "Y", leaf: "2", transform: blockTransform{orientation: 1, offset: 3}}.bytes(),
+		"Y/2": encodeTestBlock(t, color.Gray{Y: 100}),
+	})
+
+	blockContents, transforms, err := readBlockFileWithTimeout(t, leaves, "Y/0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(blockContents, *leaves.archiveReader.fileCache["Y/2"]) {
+		t.Error("chain didn't end at the block image of Y/2")
+	}
+
+	// The transform of the last reference is applied first
+	want := []blockTransform{{orientation: 6}, {orientation: 1, offset: 3}}
+	if len(transforms) != len(want) || transforms[0] != want[0] || transforms[1] != want[1] {
+		t.Errorf("chain has the transforms %+v, want %+v", transforms, want)
+	}
+}
+
+func TestReferenceCyclesAreRejected(t *testing.T) {
+	leaves := newTestLeafReader(map[string][]byte{
+		"0": blockReference{leaf: "1", transform: identityTransform}.bytes(),
+		"1": blockReference{leaf: "0", transform: identityTransform}.bytes(),
+		"2": blockReference{leaf: "2", transform: identityTransform}.bytes(),
+	})
+
+	for _, filename := range []string{"0", "2"} {
+		if _, _, err := readBlockFileWithTimeout(t, leaves, filename); err == nil {
+			t.Errorf("cycle through %s was followed without an error", filename)
+		}
+	}
+}
+
+func TestReferencesToMissingLeavesAreRejected(t *testing.T) {
+	leaves := newTestLeafReader(map[string][]byte{
+		"0":   blockReference{leaf: "1", transform: identityTransform}.bytes(),
+		"1":   blockReference{leaf: "3", transform: identityTransform}.bytes(),
+		"2":   blockReference{leaf: "", transform: identityTransform}.bytes(),
+		"3/0": encodeTestBlock(t, color.White),
+	})
+
+	for _, filename := range []string{"0", "2"} {
+		if _, _, err := readBlockFileWithTimeout(t, leaves, filename); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("reference from %s to a missing leaf returned %v, want %v", filename, err, fs.ErrNotExist)
+		}
+	}
+}
+
+func TestParseLeafFileReadsLegacyReferencesAsPath(t *testing.T) {
+	// Pseudo symlinks hold nothing but the path of their target, even if it looks like a transform
+	for _, target := range []string{"0/1/3", "Y/0/2", "Cb", "0/1\norientation=6\noffset=3"} {
+		reference, isReference, err := parseLeafFile([]byte(target), true)
+		if err != nil {
+			t.Fatalf("%q: %v", target, err)
+		}
+		if !isReference {
+			t.Fatalf("%q wasn't recognised as pseudo symlink", target)
+		}
+		if reference.getTarget() != target || !reference.transform.isIdentity() {
+			t.Errorf("%q was parsed as reference to %q with transform %+v", target, reference.getTarget(), reference.transform)
+		}
+	}
+}
+
+func TestDecodeReadsBaselineArchives(t *testing.T) {
+	// testdata/baseline.tar.gz was encoded with deduplication by the codec before reference records existed, so its references are pseudo symlinks.
+	// testdata/baseline.png is the image that codec decoded from it, with nearest neighbor upsampling.
+	want, err := utils.ReadImage("testdata/baseline.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	archiveReader, err := OpenArchiveReader("testdata/baseline.tar.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pseudoSymlinks := 0
+	for filename, fileContents := range archiveReader.Files() {
+		if isReference, _ := isLegacyReference(*fileContents); isReference && !isReservedFile(filename) {
+			pseudoSymlinks++
+		}
+	}
+	if pseudoSymlinks == 0 {
+		t.Fatal("archive holds no pseudo symlinks")
+	}
+
+	for _, streaming := range []bool{false, true} {
+		cfg := config.Default()
+		cfg.Quadtree.UpsamplingInterpolator = "NearestNeighbor"
+		cfg.Decoding.Streaming.Enable = streaming
+
+		got, err := png.Decode(bytes.NewReader(decodeArchive(t, "testdata/baseline.tar.gz", cfg)))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got.Bounds() != want.Bounds() {
+			t.Fatalf("streaming %t: decoded image has the bounds %v, want %v", streaming, got.Bounds(), want.Bounds())
+		}
+		for y := want.Bounds().Min.Y; y < want.Bounds().Max.Y; y++ {
+			for x := want.Bounds().Min.X; x < want.Bounds().Max.X; x++ {
+				if color.RGBA64Model.Convert(got.At(x, y)) != color.RGBA64Model.Convert(want.At(x, y)) {
+					t.Fatalf("streaming %t: pixel (%d, %d) is %v, want %v", streaming, x, y, got.At(x, y), want.At(x, y))
+				}
+			}
+		}
+	}
+}
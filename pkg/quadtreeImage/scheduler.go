@@ -0,0 +1,135 @@
+package quadtreeImage
+
+import (
+	"context"
+	"runtime"
+	"sync"
+
+	"github.com/xaverhimmelsbach/quadtree-block-compression/pkg/config"
+)
+
+// partitionTask creates the child with index childIndex of parent and queues the partitioning of its own children
+type partitionTask struct {
+	parent     *QuadtreeElement
+	childIndex int
+}
+
+// partitionScheduler distributes the partitioning of quadtree nodes over a fixed number of workers.
+// Tasks are kept in a shared stack, so that workers continue depth-first and release the images of finished subtrees early.
+type partitionScheduler struct {
+	// Tasks waiting for a worker
+	tasks []partitionTask
+	// Number of tasks that are queued or currently executed
+	pending int
+	// Guards tasks and pending
+	mutex sync.Mutex
+	// Signals workers that tasks were added or all tasks are done
+	cond *sync.Cond
+	// Cancels all queued tasks once it is done
+	ctx context.Context
+	// Receives every created node, may be nil
+	progress *progressTracker
+	// Number of quadtrees sharing progress, used to weight the area of leaves
+	treeCount int
+}
+
+// getWorkerCount returns the number of workers used for partitioning according to encodingConfig
+func getWorkerCount(encodingConfig config.EncodingConfig) int {
+	if !encodingConfig.Parallelism {
+		return 1
+	}
+	if encodingConfig.Workers > 0 {
+		return encodingConfig.Workers
+	}
+	return runtime.GOMAXPROCS(0)
+}
+
+// partitionTree partitions the subtree below root using workerCount workers and returns once the whole subtree is partitioned.
+// If ctx is cancelled, queued tasks are dropped and its error is returned once the running tasks are done, leaving the subtree incomplete.
+func partitionTree(ctx context.Context, root *QuadtreeElement, workerCount int, progress *progressTracker, treeCount int) error {
+	s := new(partitionScheduler)
+	s.cond = sync.NewCond(&s.mutex)
+	s.ctx = ctx
+	s.progress = progress
+	s.treeCount = treeCount
+	s.pushChildren(root)
+
+	var wg sync.WaitGroup
+	for i := 0; i < workerCount; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.work()
+		}()
+	}
+
+	wg.Wait()
+
+	return ctx.Err()
+}
+
+// pushChildren queues the creation of all children of parent if it needs further partitioning
+func (s *partitionScheduler) pushChildren(parent *QuadtreeElement) {
+	if parent.isLeaf {
+		return
+	}
+
+	parent.children = make([]*QuadtreeElement, ChildCount)
+
+	s.mutex.Lock()
+	// Push in reverse order so that the first child is taken first
+	for i := ChildCount - 1; i >= 0; i-- {
+		s.tasks = append(s.tasks, partitionTask{parent: parent, childIndex: i})
+	}
+	s.pending += ChildCount
+	s.mutex.Unlock()
+
+	s.cond.Broadcast()
+}
+
+// work executes tasks until no tasks are pending anymore
+func (s *partitionScheduler) work() {
+	for {
+		s.mutex.Lock()
+		for len(s.tasks) == 0 && s.pending > 0 {
+			s.cond.Wait()
+		}
+		if s.pending == 0 {
+			s.mutex.Unlock()
+			return
+		}
+
+		// Drop all queued tasks once partitioning was cancelled and wait for the running ones
+		if s.ctx.Err() != nil {
+			s.pending -= len(s.tasks)
+			s.tasks = s.tasks[:0]
+			isDone := s.pending == 0
+			s.mutex.Unlock()
+
+			if isDone {
+				s.cond.Broadcast()
+			}
+			continue
+		}
+
+		task := s.tasks[len(s.tasks)-1]
+		s.tasks = s.tasks[:len(s.tasks)-1]
+		s.mutex.Unlock()
+
+		// Copying, scaling and comparing the child happens on the worker
+		child := task.parent.createChild(task.childIndex)
+		task.parent.children[task.childIndex] = child
+		s.progress.add(child.getDepth(), child.getLeafArea(s.treeCount))
+		s.pushChildren(child)
+
+		s.mutex.Lock()
+		s.pending--
+		isDone := s.pending == 0
+		s.mutex.Unlock()
+
+		// Wake up idle workers so that they can return
+		if isDone {
+			s.cond.Broadcast()
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package quadtreeImage
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"math/rand"
+	"runtime"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/xaverhimmelsbach/quadtree-block-compression/pkg/config"
+)
+
+// generatePartitionImage creates an image of gradients, noise and hard edges that partitions into leaves of all sizes
+func generatePartitionImage(size int) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, size, size))
+	random := rand.New(rand.NewSource(1))
+
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			c := color.RGBA{R: uint8(x * 255 / size), G: uint8(y * 255 / size), B: 128, A: 255}
+
+			// Add noise to the lower right quadrant and a checkerboard to the upper right one
+			if x >= size/2 && y >= size/2 {
+				c.B = uint8(random.Intn(256))
+			} else if x >= size/2 && (x/16+y/16)%2 == 0 {
+				c.B = 0
+			}
+
+			img.SetRGBA(x, y, c)
+		}
+	}
+
+	return img
+}
+
+// newPartitionRoot returns the unpartitioned root of the quadtree of img
+func newPartitionRoot(t *testing.T, img image.Image, cfg *config.Config) *QuadtreeElement {
+	qti, err := NewQuadtreeImage(img, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	globalBounds := qti.baseImage.Bounds()
+	return NewQuadtreeElement("", qti.paddedImage, &globalBounds, qti.blocks, cfg)
+}
+
+// collectNodeIds returns the ids of all nodes below root and fails if a partitioned node is missing children or holds them at the wrong index
+func collectNodeIds(t *testing.T, root *QuadtreeElement) []string {
+	ids := make([]string, 0)
+
+	var walk func(node *QuadtreeElement)
+	walk = func(node *QuadtreeElement) {
+		if node.isLeaf {
+			if len(node.children) != 0 {
+				t.Errorf("leaf %q has children", node.id)
+			}
+			return
+		}
+
+		if len(node.children) != ChildCount {
+			t.Fatalf("node %q has %d children, want %d", node.id, len(node.children), ChildCount)
+		}
+		for i, child := range node.children {
+			if child == nil {
+				t.Fatalf("child %d of node %q was never created", i, node.id)
+			}
+			if child.id != node.id+strconv.Itoa(i) {
+				t.Errorf("child %d of node %q has id %q", i, node.id, child.id)
+			}
+
+			ids = append(ids, child.id)
+			walk(child)
+		}
+	}
+	walk(root)
+
+	sort.Strings(ids)
+	return ids
+}
+
+func TestPartitionTreeCreatesEveryNodeOnce(t *testing.T) {
+	img := generatePartitionImage(256)
+
+	var want []string
+	for _, workerCount := range []int{1, 8} {
+		cfg := config.Default()
+		root := newPartitionRoot(t, img, cfg)
+
+		// Count the created nodes through the progress of partitioning
+		var created int
+		ctx := WithProgress(context.Background(), func(progress Progress) { created = progress.NodesProcessed })
+		progress := newProgressTracker(ctx, StagePartition, 0)
+
+		done := make(chan error)
+		go func() { done <- partitionTree(ctx, root, workerCount, progress, 1) }()
+
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatalf("%d workers: %v", workerCount, err)
+			}
+		case <-time.After(time.Minute):
+			t.Fatalf("%d workers: partitioning didn't terminate", workerCount)
+		}
+
+		ids := collectNodeIds(t, root)
+		if len(ids) < ChildCount {
+			t.Fatalf("%d workers: root wasn't partitioned", workerCount)
+		}
+		for i := 1; i < len(ids); i++ {
+			if ids[i] == ids[i-1] {
+				t.Fatalf("%d workers: node %q was created twice", workerCount, ids[i])
+			}
+		}
+		if created != len(ids) {
+			t.Errorf("%d workers: %d nodes were created, but the quadtree holds %d", workerCount, created, len(ids))
+		}
+
+		// Without deduplication the quadtree doesn't depend on the order nodes are created in
+		if want == nil {
+			want = ids
+		} else if fmt.Sprint(ids) != fmt.Sprint(want) {
+			t.Errorf("%d workers: quadtree differs from the one partitioned by a single worker", workerCount)
+		}
+	}
+}
+
+func TestPartitionTreeStopsWhenCancelled(t *testing.T) {
+	cfg := config.Default()
+	root := newPartitionRoot(t, generatePartitionImage(256), cfg)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := partitionTree(ctx, root, 4, nil, 1); err != context.Canceled {
+		t.Fatalf("partitionTree returned %v, want %v", err, context.Canceled)
+	}
+}
+
+// BenchmarkPartition measures how partitioning scales with the number of workers and how much memory it allocates.
+// Worker counts above the number of cores of the machine are skipped, their workers can't run at the same time.
+func BenchmarkPartition(b *testing.B) {
+	img := generatePartitionImage(1024)
+
+	for _, dedup := range []bool{false, true} {
+		for _, workerCount := range []int{1, 2, 4, 8, 16} {
+			name := fmt.Sprintf("workers=%d", workerCount)
+			if dedup {
+				name = "dedup/" + name
+			}
+
+			b.Run(name, func(b *testing.B) {
+				if workerCount > runtime.NumCPU() {
+					b.Skipf("only %d cores available", runtime.NumCPU())
+				}
+
+				cfg := config.Default()
+				cfg.Quadtree.SimilarityCutoff = 0.95
+				cfg.Encoding.Parallelism = true
+				cfg.Encoding.Workers = workerCount
+				cfg.Encoding.DeduplicateBlocks.Enable = dedup
+
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					qti, err := NewQuadtreeImage(img, cfg)
+					if err != nil {
+						b.Fatal(err)
+					}
+					err = qti.Partition(context.Background())
+					if err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
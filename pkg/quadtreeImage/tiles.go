@@ -0,0 +1,230 @@
+package quadtreeImage
+
+import (
+	"context"
+	"errors"
+	"image"
+	"image/draw"
+	"io"
+	"strconv"
+
+	"github.com/xaverhimmelsbach/quadtree-block-compression/pkg/config"
+	"github.com/xaverhimmelsbach/quadtree-block-compression/pkg/utils"
+)
+
+// TileSource provides sections of an image that may be too large to be kept in memory as a whole
+type TileSource interface {
+	// Bounds returns the bounds of the whole image, which start at the origin
+	Bounds() image.Rectangle
+	// ReadTile returns the section rect of the image, rect always lies within Bounds
+	ReadTile(rect image.Rectangle) (image.Image, error)
+}
+
+// imageTileSource is a TileSource reading from an image that is already in memory
+type imageTileSource struct {
+	img image.Image
+}
+
+// NewImageTileSource returns a TileSource that provides sections of img without copying them
+func NewImageTileSource(img image.Image) TileSource {
+	return &imageTileSource{img: img}
+}
+
+// Bounds returns the bounds of img moved to the origin
+func (s *imageTileSource) Bounds() image.Rectangle {
+	return s.img.Bounds().Sub(s.img.Bounds().Min)
+}
+
+// ReadTile returns the section rect of img, relative to the upper left corner of img
+func (s *imageTileSource) ReadTile(rect image.Rectangle) (image.Image, error) {
+	return utils.SubImage(s.img, rect.Add(s.img.Bounds().Min)), nil
+}
+
+// tiledEncoder partitions and encodes a quadtree tile by tile.
+// Nodes larger than a tile are always partitioned, every node of the size of a tile is read from the source, partitioned, encoded and released before the next one.
+type tiledEncoder struct {
+	source TileSource
+	// Bounds of the image provided by source
+	imageBounds image.Rectangle
+	// Edge length of the nodes that are read from source as a whole
+	tileSize      int
+	archiveWriter *ArchiveWriter
+	// Minimal blocks of all leaves encoded so far, used for deduplication across tiles.
+	// It grows with every leaf of the whole image, not just of the current tile.
+	blocks *blockIndex
+	// Map of encoded blocks and the leaves holding them, shared by all tiles and growing like blocks if blocks are deduplicated
+	encodedLeaves map[*image.Image]blockReference
+	// Color model of the first tile, which all blocks are stored in
+	colorModel utils.ColorModel
+	// Was a transparent pixel found in any of the tiles?
+	hasAlpha bool
+	progress *progressTracker
+	config   *config.Config
+}
+
+// EncodeTiles partitions the image provided by source into a quadtree and writes it to writer as an archive in the configured format.
+// Only one subtree of the size of Encoding.Tiling.TileSize is kept in memory at a time and its leaves are written before the next one is read, so that images larger than the available memory can be encoded.
+// With Encoding.DeduplicateBlocks enabled, the minimal blocks of all leaves are kept for deduplication across tiles, so memory additionally grows with the number of leaves of the whole image.
+// Leaves can't be larger than a tile, which may lead to more leaves than encoding the whole image at once. Planar color spaces and visualizations are not supported.
+// Encoding stops between nodes once ctx is cancelled, returning its error.
+func EncodeTiles(ctx context.Context, source TileSource, writer io.Writer, cfg *config.Config, imageMetadata *utils.ImageMetadata) error {
+	space, err := getColorSpace(cfg.Quadtree.ColorSpace)
+	if err != nil {
+		return err
+	}
+	if space.isPlanar() {
+		return errors.New("tiled encoding doesn't support planar color spaces")
+	}
+
+	// The quadtree elements rely on the interpolators and the similarity metric existing
+	for _, interpolatorId := range []string{cfg.Quadtree.DownsamplingInterpolator, cfg.Quadtree.UpsamplingInterpolator} {
+		if _, err = getInterpolator(interpolatorId); err != nil {
+			return err
+		}
+	}
+	if _, err = getSimilarityMetric(cfg.Quadtree.SimilarityMetric); err != nil {
+		return err
+	}
+
+	imageBounds := source.Bounds()
+	if !imageBounds.Min.Eq(image.Point{}) {
+		return errors.New("bounds of the tile source have to start at the origin")
+	}
+	if imageBounds.Empty() {
+		return errors.New("tile source is empty")
+	}
+
+	archiveWriter, err := NewArchiveWriter(ArchiveMode(cfg.Encoding.ArchiveFormat), writer)
+	if err != nil {
+		return err
+	}
+
+	e := &tiledEncoder{
+		source:        source,
+		imageBounds:   imageBounds,
+		tileSize:      cfg.Encoding.Tiling.TileSize,
+		archiveWriter: archiveWriter,
+		blocks:        newBlockIndex(),
+		progress:      newProgressTracker(ctx, StagePartition, 0),
+		config:        cfg,
+	}
+
+	paddedSideLength := getPaddedSideLength(imageBounds)
+	err = e.encodeNode(ctx, "", image.Rect(0, 0, paddedSideLength, paddedSideLength))
+	if err != nil {
+		return err
+	}
+
+	meta := &metadata{
+		treeHeight:          getTreeHeight(paddedSideLength),
+		width:               imageBounds.Dx(),
+		height:              imageBounds.Dy(),
+		hasAlpha:            e.hasAlpha,
+		colorModel:          e.colorModel,
+		colorSpace:          ColorSpaceRGB,
+		requestedColorSpace: ColorSpaceRGB,
+		hasReferenceRecords: true,
+	}
+
+	err = writeMetadataFiles(archiveWriter, meta, imageMetadata)
+	if err != nil {
+		return err
+	}
+
+	// Close archiveWriter explicitly to flush all files to writer
+	return archiveWriter.Close()
+}
+
+// encodeNode partitions the node with identifier id covering nodeBounds of the padded image and writes its leaves in depth-first order
+func (e *tiledEncoder) encodeNode(ctx context.Context, id string, nodeBounds image.Rectangle) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	// Nodes that are completely out of bounds become leaves, like in a quadtree of the whole image.
+	// Their pixels are never visible, so the pixels of their upper left block stand in for all of them.
+	if nodeBounds.Dx() > e.tileSize && !utils.RectanglesCollide(nodeBounds, e.imageBounds) {
+		nodeBounds = image.Rectangle{Min: nodeBounds.Min, Max: nodeBounds.Min.Add(image.Pt(BlockSize, BlockSize))}
+	} else if nodeBounds.Dx() > e.tileSize {
+		// Nodes larger than a tile are partitioned without looking at their pixels
+		e.progress.add(len(id), 0)
+
+		for i := 0; i < ChildCount; i++ {
+			err := e.encodeNode(ctx, id+strconv.Itoa(i), getChildBounds(nodeBounds, i))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	tile, err := e.readTile(nodeBounds)
+	if err != nil {
+		return err
+	}
+
+	// Leaves of other tiles can only be referenced if blocks are deduplicated
+	if e.encodedLeaves == nil || !e.config.Encoding.DeduplicateBlocks.Enable {
+		e.encodedLeaves = make(map[*image.Image]blockReference)
+	}
+
+	// The tile is the root of a subtree of the quadtree, with the same identifier as in a quadtree of the whole image
+	root := NewQuadtreeElement(id, tile, &e.imageBounds, e.blocks, e.config)
+	e.progress.add(root.getDepth(), root.getLeafArea(1))
+
+	err = partitionTree(ctx, root, getWorkerCount(e.config.Encoding), e.progress, 1)
+	if err != nil {
+		return err
+	}
+
+	// Leaves were already reported while partitioning
+	return root.encode(ctx, e.archiveWriter, &e.encodedLeaves, "", nil)
+}
+
+// readTile reads the section tileBounds of the padded image from the source.
+// Pixels outside of the image repeat its nearest edge pixel, like the padding added by utils.FillSpace.
+func (e *tiledEncoder) readTile(tileBounds image.Rectangle) (image.Image, error) {
+	// Read the part of the image closest to tileBounds, which is at least one pixel wide and high
+	sourceBounds := image.Rect(
+		clampInt(tileBounds.Min.X, 0, e.imageBounds.Max.X-1),
+		clampInt(tileBounds.Min.Y, 0, e.imageBounds.Max.Y-1),
+		clampInt(tileBounds.Max.X-1, 0, e.imageBounds.Max.X-1)+1,
+		clampInt(tileBounds.Max.Y-1, 0, e.imageBounds.Max.Y-1)+1,
+	)
+	sourceTile, err := e.source.ReadTile(sourceBounds)
+	if err != nil {
+		return nil, err
+	}
+
+	// All blocks are stored in the color model of the first tile
+	if e.colorModel == "" {
+		e.colorModel = utils.GetColorModel(sourceTile)
+	}
+	if !utils.IsOpaque(sourceTile) {
+		e.hasAlpha = true
+	}
+
+	// Tiles may be returned with bounds of their own
+	offset := sourceTile.Bounds().Min.Sub(sourceBounds.Min)
+
+	tile := utils.NewImage(e.colorModel, tileBounds)
+	draw.Draw(tile, sourceBounds, sourceTile, sourceTile.Bounds().Min, draw.Src)
+	if sourceBounds.Eq(tileBounds) {
+		return tile, nil
+	}
+
+	// Fill the padding
+	for y := tileBounds.Min.Y; y < tileBounds.Max.Y; y++ {
+		for x := tileBounds.Min.X; x < tileBounds.Max.X; x++ {
+			if (image.Point{X: x, Y: y}).In(sourceBounds) {
+				continue
+			}
+
+			sourceX := clampInt(x, sourceBounds.Min.X, sourceBounds.Max.X-1)
+			sourceY := clampInt(y, sourceBounds.Min.Y, sourceBounds.Max.Y-1)
+			tile.Set(x, y, sourceTile.At(sourceX+offset.X, sourceY+offset.Y))
+		}
+	}
+
+	return tile, nil
+}
@@ -0,0 +1,98 @@
+package quadtreeImage
+
+import (
+	"image"
+	"image/draw"
+
+	"github.com/xaverhimmelsbach/quadtree-block-compression/pkg/utils"
+	drawX "golang.org/x/image/draw"
+)
+
+// upsamplingBorder is the number of samples borrowed from neighbouring leaves on each side of a block.
+// It covers the support of the widest available interpolator (CatmullRom).
+const upsamplingBorder = 2
+
+// upsampleBoundaryAware recreates the blockImage of every decoded leaf by interpolating across leaf boundaries.
+// The minimal samples of all leaves are first placed on a common grid, every leaf is then upsampled together with a border of its neighbours' samples.
+func (q *QuadtreeImage) upsampleBoundaryAware() error {
+	upsamplingInterpolator, err := getInterpolator(q.config.Quadtree.UpsamplingInterpolator)
+	if err != nil {
+		return err
+	}
+
+	leaves := q.root.getLeaves()
+	paddedBounds := q.paddedImage.Bounds()
+
+	// Place the samples of all leaves on a common grid in the resolution of the padded image
+	sampleGrid := utils.NewImage(q.colorModel, paddedBounds)
+	covered := make([]bool, paddedBounds.Dx()*paddedBounds.Dy())
+
+	for _, leaf := range leaves {
+		// Leaves that weren't encoded don't contribute any samples
+		if leaf.blockImageMinimal == nil {
+			continue
+		}
+
+		leafBounds := leaf.baseImage.Bounds()
+		blockImageMinimal := *leaf.blockImageMinimal
+		drawX.NearestNeighbor.Scale(sampleGrid, leafBounds, blockImageMinimal, blockImageMinimal.Bounds(), drawX.Src, nil)
+
+		for y := leafBounds.Min.Y; y < leafBounds.Max.Y; y++ {
+			for x := leafBounds.Min.X; x < leafBounds.Max.X; x++ {
+				covered[(y-paddedBounds.Min.Y)*paddedBounds.Dx()+x-paddedBounds.Min.X] = true
+			}
+		}
+	}
+
+	// Upsample every leaf together with the samples surrounding it
+	for _, leaf := range leaves {
+		if leaf.blockImageMinimal == nil {
+			continue
+		}
+
+		leafBounds := leaf.baseImage.Bounds()
+		blockImageMinimal := *leaf.blockImageMinimal
+		sampleCount := blockImageMinimal.Bounds().Dx()
+		sampleSize := leafBounds.Dx() / sampleCount
+
+		// Extend the minimal block by upsamplingBorder samples on each side
+		extendedBlock := utils.NewImage(q.colorModel, image.Rect(-upsamplingBorder, -upsamplingBorder, sampleCount+upsamplingBorder, sampleCount+upsamplingBorder))
+
+		for y := extendedBlock.Bounds().Min.Y; y < extendedBlock.Bounds().Max.Y; y++ {
+			for x := extendedBlock.Bounds().Min.X; x < extendedBlock.Bounds().Max.X; x++ {
+				// Position of the sample center inside of the padded image
+				gridPoint := image.Pt(leafBounds.Min.X+x*sampleSize+sampleSize/2, leafBounds.Min.Y+y*sampleSize+sampleSize/2)
+				isInside := x >= 0 && y >= 0 && x < sampleCount && y < sampleCount
+
+				if !isInside && gridPoint.In(paddedBounds) && covered[(gridPoint.Y-paddedBounds.Min.Y)*paddedBounds.Dx()+gridPoint.X-paddedBounds.Min.X] {
+					// Borrow sample from neighbouring leaf
+					extendedBlock.Set(x, y, sampleGrid.At(gridPoint.X, gridPoint.Y))
+				} else {
+					// Use own sample, replicating the edges where no neighbour is available
+					extendedBlock.Set(x, y, blockImageMinimal.At(blockImageMinimal.Bounds().Min.X+clampInt(x, 0, sampleCount-1), blockImageMinimal.Bounds().Min.Y+clampInt(y, 0, sampleCount-1)))
+				}
+			}
+		}
+
+		// Scale the extended block to the extended leaf area and cut out the leaf itself
+		extendedBounds := leafBounds.Inset(-upsamplingBorder * sampleSize)
+		extendedBlockImage := utils.Scale(extendedBlock, extendedBounds, upsamplingInterpolator)
+
+		blockImage := utils.NewImage(q.colorModel, leafBounds)
+		draw.Draw(blockImage, leafBounds, extendedBlockImage, leafBounds.Min, draw.Src)
+		leaf.blockImage = blockImage
+	}
+
+	return nil
+}
+
+// clampInt clamps value to the range from min to max
+func clampInt(value int, min int, max int) int {
+	if value < min {
+		return min
+	}
+	if value > max {
+		return max
+	}
+	return value
+}
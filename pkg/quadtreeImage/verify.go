@@ -0,0 +1,133 @@
+package quadtreeImage
+
+import (
+	"fmt"
+	"image"
+	"io"
+	"strings"
+)
+
+// Verify checks the integrity of an encoded quadtree image read from reader.
+// It returns every problem that was found, the encoded image is intact if none are returned.
+func Verify(reader io.Reader) []error {
+	archiveReader, err := NewArchiveReader(reader)
+	if err != nil {
+		return []error{err}
+	}
+
+	meta, err := readMetadata(archiveReader)
+	if err != nil {
+		return []error{fmt.Errorf("invalid meta file: %w", err)}
+	}
+
+	trees, err := getStoredTrees(meta)
+	if err != nil {
+		return []error{err}
+	}
+
+	leaves := newLeafReader(archiveReader, meta)
+	var problems []error
+
+	// Every file has to be either reserved or part of a quadtree
+	claimedFiles := make(map[string]bool)
+	for _, tree := range trees {
+		for _, filename := range tree.getLeafFilenames(archiveReader) {
+			claimedFiles[filename] = true
+		}
+	}
+	for filename := range archiveReader.Files() {
+		if !isReservedFile(filename) && !claimedFiles[filename] {
+			problems = append(problems, fmt.Errorf("file %s doesn't belong to any quadtree", filename))
+		}
+	}
+
+	for _, tree := range trees {
+		problems = append(problems, tree.verify(leaves)...)
+	}
+
+	return problems
+}
+
+// verify checks that the leaves of tree are well-formed, don't overlap, cover the whole image and hold valid block images
+func (tree storedTree) verify(leaves *leafReader) []error {
+	var problems []error
+
+	filenames := tree.getLeafFilenames(leaves.archiveReader)
+	leafPaths := make(map[string]bool, len(filenames))
+	for _, filename := range filenames {
+		treePath, _ := trimPath(tree.pathPrefix, filename)
+		leafPaths[treePath] = true
+	}
+
+	// Keep track of which minimal blocks of the padded image are covered by a leaf
+	blocksPerSide := 1 << tree.treeHeight
+	covered := make([]bool, blocksPerSide*blocksPerSide)
+
+	for _, filename := range filenames {
+		treePath, _ := trimPath(tree.pathPrefix, filename)
+		childIndices, err := parseTreePath(treePath, tree.treeHeight)
+		if err != nil {
+			problems = append(problems, fmt.Errorf("leaf %s: %w", filename, err))
+			continue
+		}
+
+		// Leaves must not be located inside of other leaves
+		childIds := strings.Split(treePath, "/")
+		for depth := 0; depth < len(childIndices); depth++ {
+			if ancestorPath := strings.Join(childIds[:depth], "/"); leafPaths[ancestorPath] {
+				problems = append(problems, fmt.Errorf("leaf %s is located inside of leaf %s", filename, joinPath(tree.pathPrefix, ancestorPath)))
+				break
+			}
+		}
+
+		// Mark the minimal blocks covered by the leaf
+		bounds := image.Rect(0, 0, blocksPerSide, blocksPerSide)
+		for _, childIndex := range childIndices {
+			bounds = getChildBounds(bounds, childIndex)
+		}
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			for x := bounds.Min.X; x < bounds.Max.X; x++ {
+				covered[y*blocksPerSide+x] = true
+			}
+		}
+
+		if err := verifyLeafFile(leaves, filename); err != nil {
+			problems = append(problems, fmt.Errorf("leaf %s: %w", filename, err))
+		}
+	}
+
+	// Every visible minimal block has to be covered, blocks in the padding may have been skipped
+	uncoveredBlocks := 0
+	for y := 0; y*BlockSize < tree.bounds.Dy(); y++ {
+		for x := 0; x*BlockSize < tree.bounds.Dx(); x++ {
+			if !covered[y*blocksPerSide+x] {
+				uncoveredBlocks++
+			}
+		}
+	}
+	if uncoveredBlocks > 0 {
+		problems = append(problems, fmt.Errorf("quadtree %q leaves %d visible blocks uncovered", tree.pathPrefix, uncoveredBlocks))
+	}
+
+	return problems
+}
+
+// verifyLeafFile checks that a leaf file either holds a block image of size BlockSize or a reference to one.
+// Chains of references have to end at an existing leaf holding a block image without passing any leaf twice.
+func verifyLeafFile(leaves *leafReader, filename string) error {
+	fileContents, err := leaves.archiveReader.Open(filename)
+	if err != nil {
+		return err
+	}
+
+	blockImage, _, err := leaves.readBlock(*fileContents)
+	if err != nil {
+		return err
+	}
+
+	if blockImage.Bounds().Dx() != BlockSize || blockImage.Bounds().Dy() != BlockSize {
+		return fmt.Errorf("block image has size %dx%d instead of %dx%d", blockImage.Bounds().Dx(), blockImage.Bounds().Dy(), BlockSize, BlockSize)
+	}
+
+	return nil
+}
@@ -0,0 +1,128 @@
+package quadtreeImage
+
+import (
+	"image"
+	"math"
+	"sort"
+)
+
+// vpItem is a block stored in a vpTree together with its blockVector
+type vpItem struct {
+	block  *image.Image
+	vector blockVector
+}
+
+// vpNode is a node of a vantage point tree holding a single block
+type vpNode struct {
+	vpItem
+	// Blocks at most radius away from vector are stored inside, blocks at least radius away outside
+	radius  float64
+	inside  *vpNode
+	outside *vpNode
+}
+
+// vpTree finds all blocks within a distance of a block by getBlockDistance.
+// Vantage point trees can only be balanced when they are built from all of their blocks at once, but blocks are added one by one while partitioning.
+// The blocks are therefore kept in balanced trees of 1, 2, 4, ... blocks, which are merged and rebuilt like the digits of a binary counter.
+// Every block is rebuilt at most once per tree size, so no order of blocks leads to degenerate trees.
+type vpTree struct {
+	// levels[i] holds no blocks or 2^i blocks, roots[i] is the tree built from them
+	levels [][]vpItem
+	roots  []*vpNode
+}
+
+// insert adds block with the blockVector vector to the tree
+func (t *vpTree) insert(block *image.Image, vector blockVector) {
+	carry := []vpItem{{block: block, vector: vector}}
+
+	for i := 0; ; i++ {
+		if i == len(t.levels) {
+			t.levels = append(t.levels, nil)
+			t.roots = append(t.roots, nil)
+		}
+
+		if len(t.levels[i]) == 0 {
+			t.levels[i] = carry
+			t.roots[i] = buildVPNode(append([]vpItem(nil), carry...))
+			return
+		}
+
+		// Merge the trees of the same size into one of twice the size
+		carry = append(carry, t.levels[i]...)
+		t.levels[i] = nil
+		t.roots[i] = nil
+	}
+}
+
+// buildVPNode builds a balanced vantage point tree from items and returns its root, items are reordered
+func buildVPNode(items []vpItem) *vpNode {
+	if len(items) == 0 {
+		return nil
+	}
+
+	// The last item lies on the edge of the items of the parent node, which makes a good vantage point
+	node := &vpNode{vpItem: items[len(items)-1]}
+	items = items[:len(items)-1]
+	if len(items) == 0 {
+		return node
+	}
+
+	sorter := &vpItemSorter{items: items, distances: make([]float64, len(items))}
+	for i, item := range items {
+		sorter.distances[i] = getBlockDistance(node.vector, item.vector, math.Inf(1))
+	}
+	sort.Sort(sorter)
+
+	// Split at the median, so both subtrees hold the same number of blocks even if many blocks have the same distance
+	median := len(items) / 2
+	node.radius = sorter.distances[median]
+	node.inside = buildVPNode(items[:median])
+	node.outside = buildVPNode(items[median:])
+
+	return node
+}
+
+// vpItemSorter sorts items by their distances to a vantage point
+type vpItemSorter struct {
+	items     []vpItem
+	distances []float64
+}
+
+func (s *vpItemSorter) Len() int           { return len(s.items) }
+func (s *vpItemSorter) Less(i, j int) bool { return s.distances[i] < s.distances[j] }
+func (s *vpItemSorter) Swap(i, j int) {
+	s.items[i], s.items[j] = s.items[j], s.items[i]
+	s.distances[i], s.distances[j] = s.distances[j], s.distances[i]
+}
+
+// search returns all blocks that are at most maxDistance away from vector
+func (t *vpTree) search(vector blockVector, maxDistance float64) []*image.Image {
+	blocks := make([]*image.Image, 0)
+
+	// Subtrees are skipped if the triangle inequality rules out that they hold blocks within maxDistance
+	var visit func(node *vpNode)
+	visit = func(node *vpNode) {
+		if node == nil {
+			return
+		}
+
+		// Blocks farther away than the radius of node plus maxDistance can only be outside, so their distance doesn't need to be known exactly
+		distance := getBlockDistance(vector, node.vector, node.radius+maxDistance)
+		if distance <= maxDistance {
+			blocks = append(blocks, node.block)
+		}
+
+		if distance-maxDistance <= node.radius {
+			visit(node.inside)
+		}
+		if distance+maxDistance >= node.radius {
+			visit(node.outside)
+		}
+	}
+
+	for _, root := range t.roots {
+		visit(root)
+	}
+
+	return blocks
+}
@@ -0,0 +1,121 @@
+package quadtreeImage
+
+import (
+	"image"
+	"image/color"
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+// generateBlocks creates count 8-bit blocks of colorModel that form clusters of near-duplicates, some of which are exact duplicates
+func generateBlocks(random *rand.Rand, count int, gray bool) []image.Image {
+	bounds := image.Rect(0, 0, BlockSize, BlockSize)
+	blocks := make([]image.Image, 0, count)
+
+	for len(blocks) < count {
+		base := make([]uint8, 3*BlockSize*BlockSize)
+		for i := range base {
+			base[i] = uint8(random.Intn(256))
+		}
+
+		for i := 0; i < 8 && len(blocks) < count; i++ {
+			// Vary the channels by a few levels and replace some pixels completely
+			maxDifference := random.Intn(6)
+			var block interface {
+				image.Image
+				Set(x, y int, c color.Color)
+			}
+			if gray {
+				block = image.NewGray(bounds)
+			} else {
+				block = image.NewRGBA(bounds)
+			}
+
+			for p := 0; p < BlockSize*BlockSize; p++ {
+				c := color.RGBA{R: base[3*p], G: base[3*p+1], B: base[3*p+2], A: 255}
+				if random.Intn(16) == 0 {
+					c.R, c.G, c.B = uint8(random.Intn(256)), uint8(random.Intn(256)), uint8(random.Intn(256))
+				} else if maxDifference > 0 {
+					c.R += uint8(random.Intn(maxDifference))
+					c.G += uint8(random.Intn(maxDifference))
+					c.B += uint8(random.Intn(maxDifference))
+				}
+				block.Set(p%BlockSize, p/BlockSize, c)
+			}
+			blocks = append(blocks, block)
+		}
+	}
+
+	return blocks
+}
+
+// getInsertOrders returns blocks in random order, sorted by their first channel value and repeating a few blocks many times
+func getInsertOrders(random *rand.Rand, blocks []image.Image) map[string][]image.Image {
+	shuffled := append([]image.Image(nil), blocks...)
+	random.Shuffle(len(shuffled), func(i, j int) { shuffled[i], shuffled[j] = shuffled[j], shuffled[i] })
+
+	sorted := append([]image.Image(nil), blocks...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		_, a := getBlockSignature(sorted[i])
+		_, b := getBlockSignature(sorted[j])
+		return a.values[0] < b.values[0]
+	})
+
+	repetitive := make([]image.Image, 0, len(blocks))
+	for i := range blocks {
+		repetitive = append(repetitive, blocks[i%8])
+	}
+
+	return map[string][]image.Image{"random": shuffled, "sorted": sorted, "repetitive": repetitive}
+}
+
+func TestVPTreeSearchMatchesBruteForce(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+
+	for _, gray := range []bool{false, true} {
+		blocks := generateBlocks(random, 300, gray)
+		queries := generateBlocks(random, 40, gray)
+
+		for name, order := range getInsertOrders(random, blocks) {
+			var tree vpTree
+			vectors := make([]blockVector, len(order))
+			items := make([]*image.Image, len(order))
+			for i := range order {
+				_, vectors[i] = getBlockSignature(order[i])
+				items[i] = &order[i]
+				tree.insert(items[i], vectors[i])
+			}
+
+			for _, query := range append(queries, order[:10]...) {
+				_, vector := getBlockSignature(query)
+				for _, maxDistance := range []float64{0, 0.05, getSearchRadius(vector, 0.9), getSearchRadius(vector, 0.5), 2} {
+					want := make(map[*image.Image]bool)
+					for i := range vectors {
+						if getBlockDistance(vector, vectors[i], maxDistance) <= maxDistance {
+							want[items[i]] = true
+						}
+					}
+
+					found := tree.search(vector, maxDistance)
+					got := make(map[*image.Image]bool)
+					for _, block := range found {
+						if got[block] {
+							t.Fatalf("gray %t, %s order: search within %v returned a block twice", gray, name, maxDistance)
+						}
+						got[block] = true
+					}
+
+					if len(got) != len(want) {
+						t.Fatalf("gray %t, %s order: search within %v found %d blocks, want %d", gray, name, maxDistance, len(got), len(want))
+					}
+					for block := range want {
+						if !got[block] {
+							t.Fatalf("gray %t, %s order: search within %v missed a block", gray, name, maxDistance)
+						}
+					}
+				}
+			}
+		}
+	}
+}
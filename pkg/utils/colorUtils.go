@@ -0,0 +1,84 @@
+package utils
+
+import (
+	"fmt"
+	"image"
+
+	"golang.org/x/image/draw"
+)
+
+// ColorModel identifies the pixel format images are processed and stored in
+type ColorModel string
+
+const (
+	// 8-bit color channels with alpha
+	ColorModelRGBA ColorModel = "RGBA"
+	// 16-bit color channels with alpha
+	ColorModelRGBA64 ColorModel = "RGBA64"
+	// 8-bit grayscale
+	ColorModelGray ColorModel = "Gray"
+	// 16-bit grayscale
+	ColorModelGray16 ColorModel = "Gray16"
+)
+
+// GetColorModel returns the ColorModel that preserves the depth and channel count of img.
+// Images of unknown types are processed as RGBA.
+func GetColorModel(img image.Image) ColorModel {
+	switch img.(type) {
+	case *image.Gray:
+		return ColorModelGray
+	case *image.Gray16:
+		return ColorModelGray16
+	case *image.RGBA64, *image.NRGBA64:
+		return ColorModelRGBA64
+	default:
+		return ColorModelRGBA
+	}
+}
+
+// ParseColorModel returns the ColorModel called name
+func ParseColorModel(name string) (ColorModel, error) {
+	switch colorModel := ColorModel(name); colorModel {
+	case ColorModelRGBA, ColorModelRGBA64, ColorModelGray, ColorModelGray16:
+		return colorModel, nil
+	default:
+		return "", fmt.Errorf("unknown color model: %q", name)
+	}
+}
+
+// Is16Bit returns whether colorModel stores more than 8 bits per channel
+func (colorModel ColorModel) Is16Bit() bool {
+	return colorModel == ColorModelRGBA64 || colorModel == ColorModelGray16
+}
+
+// NewImage creates an empty image with the given bounds in colorModel
+func NewImage(colorModel ColorModel, bounds image.Rectangle) draw.Image {
+	switch colorModel {
+	case ColorModelGray:
+		return image.NewGray(bounds)
+	case ColorModelGray16:
+		return image.NewGray16(bounds)
+	case ColorModelRGBA64:
+		return image.NewRGBA64(bounds)
+	default:
+		return image.NewRGBA(bounds)
+	}
+}
+
+// NewImageLike creates an empty image with the given bounds in the ColorModel of img
+func NewImageLike(img image.Image, bounds image.Rectangle) draw.Image {
+	return NewImage(GetColorModel(img), bounds)
+}
+
+// ConvertImage returns a copy of img in colorModel
+func ConvertImage(img image.Image, colorModel ColorModel) draw.Image {
+	convertedImage := NewImage(colorModel, img.Bounds())
+	draw.Draw(convertedImage, convertedImage.Bounds(), img, img.Bounds().Min, draw.Src)
+	return convertedImage
+}
+
+// IsGray returns whether img only has a single luminance channel
+func IsGray(img image.Image) bool {
+	colorModel := GetColorModel(img)
+	return colorModel == ColorModelGray || colorModel == ColorModelGray16
+}
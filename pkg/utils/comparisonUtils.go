@@ -5,110 +5,305 @@ import (
 	"image"
 )
 
+// Maximal differences of 16-bit channels for them to be considered similar by ComparePixelsWeighted.
+// These are 1000 times the channel weights, rounded down as channels only take integer values.
+const (
+	maxRedDifference   uint32 = 298
+	maxGreenDifference uint32 = 587
+	maxBlueDifference  uint32 = 114
+	maxGrayDifference  uint32 = 999
+)
+
+// ChannelTolerance describes how ComparePixelsWeighted compares a channel
+type ChannelTolerance struct {
+	// Share of the similarity of a pixel the channel contributes if it matches
+	Weight float64
+	// Maximal difference of the 16-bit values of the channel for it to match
+	MaxDifference uint32
+}
+
+// WeightedColorTolerances returns the tolerances of the red, green and blue channels of color images compared by ComparePixelsWeighted
+func WeightedColorTolerances() []ChannelTolerance {
+	return []ChannelTolerance{
+		{Weight: weightedRed, MaxDifference: maxRedDifference},
+		{Weight: weightedGreen, MaxDifference: maxGreenDifference},
+		{Weight: weightedBlue, MaxDifference: maxBlueDifference},
+	}
+}
+
+// WeightedGrayTolerances returns the tolerance of the single luminance channel of grayscale images compared by ComparePixelsWeighted
+func WeightedGrayTolerances() []ChannelTolerance {
+	return []ChannelTolerance{{Weight: weightedGray, MaxDifference: maxGrayDifference}}
+}
+
+// channelMatches counts how many pixels matched per channel during a weighted comparison
+type channelMatches struct {
+	red   int
+	green int
+	blue  int
+	// Matches of grayscale images, which are compared on a single channel
+	gray int
+}
+
+// weightedSum returns the matches weighted by the perceived luminance of their channels
+func (m channelMatches) weightedSum() float64 {
+	return float64(m.red)*weightedRed + float64(m.green)*weightedGreen + float64(m.blue)*weightedBlue + float64(m.gray)*weightedGray
+}
+
 // ComparePixelsExact naively compares two images by checking how many of the pixels between them are identical.
+// Only pixels inside of globalBounds are compared.
 // It returns a float that ranges between 0 (no matches) and 1 (identical pictures)
-func ComparePixelsExact(imageA *image.RGBA, imageB *image.RGBA, globalBounds image.Rectangle) (float64, error) {
+func ComparePixelsExact(imageA image.Image, imageB image.Image, globalBounds image.Rectangle) (float64, error) {
 	// Ensure that images dimensions and origin points are equal
-	if imageA.Bounds().Min.X != imageB.Bounds().Min.X ||
-		imageA.Bounds().Min.Y != imageB.Bounds().Min.Y ||
-		imageA.Bounds().Max.X != imageB.Bounds().Max.X ||
-		imageA.Bounds().Max.Y != imageB.Bounds().Max.Y {
+	if !imageA.Bounds().Eq(imageB.Bounds()) {
 		return 0, fmt.Errorf("bounds for image A (%v) and image B (%v) do not match", imageA.Bounds(), imageB.Bounds())
 	}
 
+	// The padding is of no interest
+	bounds := imageA.Bounds().Intersect(globalBounds)
+
+	// If none of the pixels are inside bounds, signal that no further partitioning is required
+	if bounds.Empty() {
+		return 1, nil
+	}
+
+	var matches int
+	rgbaA, isRGBAA := imageA.(*image.RGBA)
+	rgbaB, isRGBAB := imageB.(*image.RGBA)
+	grayA, isGrayA := imageA.(*image.Gray)
+	grayB, isGrayB := imageB.(*image.Gray)
+
+	switch {
+	case isRGBAA && isRGBAB:
+		matches = compareRGBAExact(rgbaA, rgbaB, bounds)
+	case isGrayA && isGrayB:
+		matches = compareGrayExact(grayA, grayB, bounds)
+	default:
+		matches = compareGenericExact(imageA, imageB, bounds)
+	}
+
+	similarity := float64(matches) / float64(bounds.Dx()*bounds.Dy())
+	return similarity, nil
+}
+
+// compareRGBAExact counts the identical pixels inside of bounds, reading the pixel buffers directly
+func compareRGBAExact(imageA *image.RGBA, imageB *image.RGBA, bounds image.Rectangle) int {
 	matches := 0
-	skipped := 0
+	rowLength := bounds.Dx() * 4
 
-	// Compare every pixel across both images
-	for x := imageA.Bounds().Min.X; x < imageA.Bounds().Max.X; x++ {
-		for y := imageA.Bounds().Min.Y; y < imageA.Bounds().Max.Y; y++ {
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		offsetA := imageA.PixOffset(bounds.Min.X, y)
+		offsetB := imageB.PixOffset(bounds.Min.X, y)
+		rowA := imageA.Pix[offsetA : offsetA+rowLength : offsetA+rowLength]
+		rowB := imageB.Pix[offsetB : offsetB+rowLength : offsetB+rowLength]
 
-			// The padding is of no interest
-			// TODO: Use function to calculate overlapping area instead of checking every pixel seperately
-			if PointCollides(image.Point{X: x, Y: y}, globalBounds) {
-				skipped++
-				continue
+		for i := 0; i < rowLength; i += 4 {
+			if rowA[i] == rowB[i] && rowA[i+1] == rowB[i+1] && rowA[i+2] == rowB[i+2] && rowA[i+3] == rowB[i+3] {
+				matches++
 			}
+		}
+	}
 
-			aR, aG, aB, _ := imageA.At(x, y).RGBA()
-			bR, bG, bB, _ := imageB.At(x, y).RGBA()
+	return matches
+}
 
-			if aR == bR && aG == bG && aB == bB {
+// compareGrayExact counts the identical pixels of two grayscale images inside of bounds, reading the pixel buffers directly
+func compareGrayExact(imageA *image.Gray, imageB *image.Gray, bounds image.Rectangle) int {
+	matches := 0
+	rowLength := bounds.Dx()
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		offsetA := imageA.PixOffset(bounds.Min.X, y)
+		offsetB := imageB.PixOffset(bounds.Min.X, y)
+		rowA := imageA.Pix[offsetA : offsetA+rowLength : offsetA+rowLength]
+		rowB := imageB.Pix[offsetB : offsetB+rowLength : offsetB+rowLength]
+
+		for i := 0; i < rowLength; i++ {
+			if rowA[i] == rowB[i] {
 				matches++
 			}
 		}
 	}
 
-	// If none of the checked pixels were inside bounds, signal that no further partitioning is required
-	relevantPixels := imageA.Bounds().Dx()*imageA.Bounds().Dy() - skipped
-	if relevantPixels <= 0 {
-		return 1, nil
+	return matches
+}
+
+// compareGenericExact counts the identical pixels inside of bounds for images of any type
+func compareGenericExact(imageA image.Image, imageB image.Image, bounds image.Rectangle) int {
+	matches := 0
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			aR, aG, aB, aA := imageA.At(x, y).RGBA()
+			bR, bG, bB, bA := imageB.At(x, y).RGBA()
+
+			if aR == bR && aG == bG && aB == bB && aA == bA {
+				matches++
+			}
+		}
 	}
 
-	// Else compute similarity without OOB pixels
-	similarity := float64(matches) / float64(relevantPixels)
-	return similarity, nil
+	return matches
 }
 
-func ComparePixelsWeighted(imageA *image.RGBA, imageB *image.RGBA, globalBounds image.Rectangle) (float64, error) {
+// ComparePixelsWeighted compares two images by checking how close the color channels of their pixels are, weighted by their perceived luminance.
+// Pixels whose alpha values differ too much are treated as not matching.
+// Grayscale images are compared on their single luminance channel.
+// Channels are compared in 16-bit depth, so 16-bit images keep their precision.
+// Only pixels inside of globalBounds are compared.
+// It returns a float that ranges between 0 (no matches) and 1 (identical pictures)
+func ComparePixelsWeighted(imageA image.Image, imageB image.Image, globalBounds image.Rectangle) (float64, error) {
 	// Ensure that images dimensions and origin points are equal
-	if imageA.Bounds().Min.X != imageB.Bounds().Min.X ||
-		imageA.Bounds().Min.Y != imageB.Bounds().Min.Y ||
-		imageA.Bounds().Max.X != imageB.Bounds().Max.X ||
-		imageA.Bounds().Max.Y != imageB.Bounds().Max.Y {
+	if !imageA.Bounds().Eq(imageB.Bounds()) {
 		return 0, fmt.Errorf("bounds for image A (%v) and image B (%v) do not match", imageA.Bounds(), imageB.Bounds())
 	}
 
-	var matches float64
-	var skipped int
+	// The padding is of no interest
+	bounds := imageA.Bounds().Intersect(globalBounds)
+
+	// If none of the pixels are inside bounds signal that no further partitioning is required
+	// TODO: this shouldn't happen for quadtreeElements as they check for collisions before calling this function
+	if bounds.Empty() {
+		fmt.Println("0 relevant pixels found. This shouldn't happen when calling ComparePixelsWeighted from compareImages on a quadtreeElement")
+		return 1, nil
+	}
+
+	var matches channelMatches
+	rgbaA, isRGBAA := imageA.(*image.RGBA)
+	rgbaB, isRGBAB := imageB.(*image.RGBA)
+	grayA, isGrayA := imageA.(*image.Gray)
+	grayB, isGrayB := imageB.(*image.Gray)
 
-	// Compare every pixel across both images
-	for x := imageA.Bounds().Min.X; x < imageA.Bounds().Max.X; x++ {
-		for y := imageA.Bounds().Min.Y; y < imageA.Bounds().Max.Y; y++ {
+	switch {
+	case isRGBAA && isRGBAB:
+		matches = compareRGBAWeighted(rgbaA, rgbaB, bounds)
+	case isGrayA && isGrayB:
+		matches = compareGrayWeighted(grayA, grayB, bounds)
+	default:
+		matches = compareGenericWeighted(imageA, imageB, bounds)
+	}
 
-			// The padding is of no interest
-			if PointCollides(image.Point{X: x, Y: y}, globalBounds) {
-				skipped++
-				continue
-			}
+	similarity := matches.weightedSum() / float64(bounds.Dx()*bounds.Dy())
+	return similarity, nil
+}
 
-			aR, aG, aB, _ := imageA.At(x, y).RGBA()
-			bR, bG, bB, _ := imageB.At(x, y).RGBA()
+// get8BitDifference converts the maximal difference of 16-bit channels to 8-bit channels, which are expanded to 16 bits by multiplying with 0x101
+func get8BitDifference(maxDifference uint32) uint8 {
+	return uint8(maxDifference / 0x101)
+}
 
-			// Use individual color channel weights
-			if InRange(float64(aR), float64(bR), 1000*weightedRed) {
-				matches += weightedRed
+// absDifference returns the absolute difference of two 8-bit channels
+func absDifference(a uint8, b uint8) uint8 {
+	if a > b {
+		return a - b
+	}
+	return b - a
+}
+
+// compareRGBAWeighted counts the matching channels inside of bounds, reading the pixel buffers directly
+func compareRGBAWeighted(imageA *image.RGBA, imageB *image.RGBA, bounds image.Rectangle) channelMatches {
+	var matches channelMatches
+	rowLength := bounds.Dx() * 4
+
+	maxRed := get8BitDifference(maxRedDifference)
+	maxGreen := get8BitDifference(maxGreenDifference)
+	maxBlue := get8BitDifference(maxBlueDifference)
+	maxAlpha := get8BitDifference(uint32(maxAlphaDifference))
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		offsetA := imageA.PixOffset(bounds.Min.X, y)
+		offsetB := imageB.PixOffset(bounds.Min.X, y)
+		rowA := imageA.Pix[offsetA : offsetA+rowLength : offsetA+rowLength]
+		rowB := imageB.Pix[offsetB : offsetB+rowLength : offsetB+rowLength]
+
+		for i := 0; i < rowLength; i += 4 {
+			// Pixels with differing transparency don't match at all
+			if absDifference(rowA[i+3], rowB[i+3]) > maxAlpha {
+				continue
 			}
 
-			if InRange(float64(aG), float64(bG), 1000*weightedGreen) {
-				matches += weightedGreen
+			if absDifference(rowA[i], rowB[i]) <= maxRed {
+				matches.red++
+			}
+			if absDifference(rowA[i+1], rowB[i+1]) <= maxGreen {
+				matches.green++
 			}
+			if absDifference(rowA[i+2], rowB[i+2]) <= maxBlue {
+				matches.blue++
+			}
+		}
+	}
+
+	return matches
+}
 
-			if InRange(float64(aB), float64(bB), 1000*weightedBlue) {
-				matches += weightedBlue
+// compareGrayWeighted counts the matching pixels of two grayscale images inside of bounds, reading the pixel buffers directly
+func compareGrayWeighted(imageA *image.Gray, imageB *image.Gray, bounds image.Rectangle) channelMatches {
+	var matches channelMatches
+	rowLength := bounds.Dx()
+	maxGray := get8BitDifference(maxGrayDifference)
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		offsetA := imageA.PixOffset(bounds.Min.X, y)
+		offsetB := imageB.PixOffset(bounds.Min.X, y)
+		rowA := imageA.Pix[offsetA : offsetA+rowLength : offsetA+rowLength]
+		rowB := imageB.Pix[offsetB : offsetB+rowLength : offsetB+rowLength]
+
+		for i := 0; i < rowLength; i++ {
+			if absDifference(rowA[i], rowB[i]) <= maxGray {
+				matches.gray++
 			}
 		}
 	}
 
-	// If none of the checked pixels were inside bounds signal that no further partitioning is required
-	// TODO: this shouldn't happen for quadtreeElements as they check for collisions before calling this function
-	relevantPixels := imageA.Bounds().Dx()*imageA.Bounds().Dy() - skipped
-	if relevantPixels <= 0 {
-		fmt.Println("0 relevant pixels found. This shouldn't happen when calling ComparePixelsWeighted from compareImages on a quadtreeElement")
-		return 1, nil
+	return matches
+}
+
+// compareGenericWeighted counts the matching channels inside of bounds for images of any type
+func compareGenericWeighted(imageA image.Image, imageB image.Image, bounds image.Rectangle) channelMatches {
+	var matches channelMatches
+	gray := IsGray(imageA) && IsGray(imageB)
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			aR, aG, aB, aA := imageA.At(x, y).RGBA()
+			bR, bG, bB, bA := imageB.At(x, y).RGBA()
+
+			// Pixels with differing transparency don't match at all
+			if absDifference32(aA, bA) > uint32(maxAlphaDifference) {
+				continue
+			}
+
+			// Grayscale images have identical color channels, so compare just one of them with the combined weight
+			if gray {
+				if absDifference32(aR, bR) <= maxGrayDifference {
+					matches.gray++
+				}
+				continue
+			}
+
+			// Use individual color channel weights
+			if absDifference32(aR, bR) <= maxRedDifference {
+				matches.red++
+			}
+			if absDifference32(aG, bG) <= maxGreenDifference {
+				matches.green++
+			}
+			if absDifference32(aB, bB) <= maxBlueDifference {
+				matches.blue++
+			}
+		}
 	}
 
-	// Else compute similarity without OOB pixels
-	similarity := matches / float64(relevantPixels)
-	return similarity, nil
+	return matches
 }
 
-// PointCollides returns true if p collides with r
-func PointCollides(p image.Point, r image.Rectangle) bool {
-	return p.X < r.Min.X ||
-		p.X > r.Max.X ||
-		p.Y < r.Min.Y ||
-		p.Y > r.Max.Y
+// absDifference32 returns the absolute difference of two 16-bit channels as returned by color.Color.RGBA
+func absDifference32(a uint32, b uint32) uint32 {
+	if a > b {
+		return a - b
+	}
+	return b - a
 }
 
 // RectanglesCollide returns true if r1 and r2 collide
@@ -0,0 +1,190 @@
+package utils
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math/rand"
+	"testing"
+)
+
+// genericImage hides the concrete type of an image, forcing the comparison functions onto their generic path
+type genericImage struct {
+	image.Image
+}
+
+// getGenericImage returns an image with the same pixels as img that is compared on the generic path.
+// Grayscale images are converted to 16-bit instead of being wrapped so that they are still recognized as grayscale.
+func getGenericImage(img image.Image) image.Image {
+	if IsGray(img) {
+		return ConvertImage(img, ColorModelGray16)
+	}
+	return genericImage{img}
+}
+
+// comparison is a comparison function that has a fast path for the pixel buffers of RGBA and grayscale images
+type comparison struct {
+	name    string
+	compare func(imageA image.Image, imageB image.Image, globalBounds image.Rectangle) (float64, error)
+}
+
+var comparisons = []comparison{
+	{name: "Exact", compare: ComparePixelsExact},
+	{name: "Weighted", compare: ComparePixelsWeighted},
+}
+
+// generateImages creates two noisy images of colorModel inside of bounds whose channels differ by up to maxDifference.
+// Transparent pixels are only generated if withAlpha is set.
+func generateImages(random *rand.Rand, colorModel ColorModel, bounds image.Rectangle, maxDifference int, withAlpha bool) (image.Image, image.Image) {
+	imageA := NewImage(colorModel, bounds)
+	imageB := NewImage(colorModel, bounds)
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := color.NRGBA{R: uint8(random.Intn(256)), G: uint8(random.Intn(256)), B: uint8(random.Intn(256)), A: 255}
+			if withAlpha && random.Intn(4) == 0 {
+				c.A = uint8(random.Intn(256))
+			}
+			imageA.Set(x, y, c)
+
+			c.R += uint8(random.Intn(maxDifference + 1))
+			c.G += uint8(random.Intn(maxDifference + 1))
+			c.B += uint8(random.Intn(maxDifference + 1))
+			if withAlpha && random.Intn(8) == 0 {
+				c.A += uint8(random.Intn(3))
+			}
+			imageB.Set(x, y, c)
+		}
+	}
+
+	return imageA, imageB
+}
+
+// randomRectangle returns a rectangle that overlaps bounds partly or covers it completely
+func randomRectangle(random *rand.Rand, bounds image.Rectangle) image.Rectangle {
+	margin := bounds.Dx()/2 + 1
+	for {
+		x0 := bounds.Min.X - margin + random.Intn(bounds.Dx()+2*margin)
+		y0 := bounds.Min.Y - margin + random.Intn(bounds.Dy()+2*margin)
+		r := image.Rect(x0, y0, x0+random.Intn(bounds.Dx()+margin)+1, y0+random.Intn(bounds.Dy()+margin)+1)
+
+		// Quadtree nodes outside of the global bounds are never compared
+		if r.Overlaps(bounds) {
+			return r
+		}
+	}
+}
+
+func TestComparePixelsFastPathsMatchGenericPath(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+
+	for i := 0; i < 200; i++ {
+		for _, colorModel := range []ColorModel{ColorModelRGBA, ColorModelGray} {
+			// Images of quadtree nodes are views into a larger image, so their origin is rarely at 0
+			origin := image.Pt(random.Intn(20)-10, random.Intn(20)-10)
+			bounds := image.Rectangle{Min: origin, Max: origin.Add(image.Pt(random.Intn(24)+1, random.Intn(24)+1))}
+			imageA, imageB := generateImages(random, colorModel, bounds, random.Intn(5), colorModel == ColorModelRGBA)
+			genericImageA, genericImageB := getGenericImage(imageA), getGenericImage(imageB)
+			globalBounds := randomRectangle(random, bounds)
+
+			for _, c := range comparisons {
+				similarity, err := c.compare(imageA, imageB, globalBounds)
+				if err != nil {
+					t.Fatal(err)
+				}
+				genericSimilarity, err := c.compare(genericImageA, genericImageB, globalBounds)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if similarity != genericSimilarity {
+					t.Fatalf("%s comparison of %s images in %v with global bounds %v differs between buffer (%v) and generic path (%v)", c.name, colorModel, bounds, globalBounds, similarity, genericSimilarity)
+				}
+			}
+		}
+	}
+}
+
+func TestComparePixelsExcludesMaxEdgeOfGlobalBounds(t *testing.T) {
+	globalBounds := image.Rect(0, 0, 12, 12)
+
+	for _, colorModel := range []ColorModel{ColorModelRGBA, ColorModelGray} {
+		imageA := NewImage(colorModel, image.Rect(0, 0, 16, 16))
+		imageB := NewImage(colorModel, image.Rect(0, 0, 16, 16))
+
+		// Pixels on the Max edge of globalBounds belong to the padding
+		for i := 0; i < 16; i++ {
+			imageB.Set(globalBounds.Max.X, i, color.White)
+			imageB.Set(i, globalBounds.Max.Y, color.White)
+		}
+
+		// The weights of ComparePixelsWeighted don't add up to exactly 1, so compare with the similarity of identical images
+		identicalSimilarities := make([]float64, len(comparisons))
+		for i, c := range comparisons {
+			var err error
+			identicalSimilarities[i], err = c.compare(imageA, imageA, globalBounds)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, img := range [][2]image.Image{{imageA, imageB}, {getGenericImage(imageA), getGenericImage(imageB)}} {
+				similarity, err := c.compare(img[0], img[1], globalBounds)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if similarity != identicalSimilarities[i] {
+					t.Errorf("%s comparison of %T images compares the padding, similarity = %v", c.name, img[0], similarity)
+				}
+			}
+		}
+
+		// The last row inside of globalBounds is compared
+		imageB.Set(0, globalBounds.Max.Y-1, color.White)
+		for i, c := range comparisons {
+			similarity, err := c.compare(imageA, imageB, globalBounds)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if similarity == identicalSimilarities[i] {
+				t.Errorf("%s comparison of %s images ignores the last row inside of the global bounds", c.name, colorModel)
+			}
+		}
+	}
+}
+
+// benchmarkComparePixels measures compare on the pixel buffers and the generic path of RGBA and grayscale images of several sizes
+func benchmarkComparePixels(b *testing.B, compare func(imageA image.Image, imageB image.Image, globalBounds image.Rectangle) (float64, error)) {
+	for _, colorModel := range []ColorModel{ColorModelRGBA, ColorModelGray} {
+		for _, size := range []int{8, 64, 512} {
+			imageA, imageB := generateImages(rand.New(rand.NewSource(1)), colorModel, image.Rect(0, 0, size, size), 3, false)
+			// Leave out the last rows and columns like the padding of a quadtree
+			globalBounds := image.Rect(0, 0, size-size/4, size-size/4)
+
+			paths := []struct {
+				name           string
+				imageA, imageB image.Image
+			}{
+				{name: "buffer", imageA: imageA, imageB: imageB},
+				{name: "generic", imageA: getGenericImage(imageA), imageB: getGenericImage(imageB)},
+			}
+			for _, path := range paths {
+				b.Run(fmt.Sprintf("%s/%d/%s", colorModel, size, path.name), func(b *testing.B) {
+					b.ReportAllocs()
+					for i := 0; i < b.N; i++ {
+						if _, err := compare(path.imageA, path.imageB, globalBounds); err != nil {
+							b.Fatal(err)
+						}
+					}
+				})
+			}
+		}
+	}
+}
+
+func BenchmarkComparePixelsExact(b *testing.B) {
+	benchmarkComparePixels(b, ComparePixelsExact)
+}
+
+func BenchmarkComparePixelsWeighted(b *testing.B) {
+	benchmarkComparePixels(b, ComparePixelsWeighted)
+}
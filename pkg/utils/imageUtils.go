@@ -11,6 +11,9 @@ const (
 	weightedRed   float64 = 0.2989
 	weightedGreen float64 = 0.5870
 	weightedBlue  float64 = 0.1140
+	weightedGray  float64 = weightedRed + weightedGreen + weightedBlue
+	// Maximal difference of the alpha channels of two pixels (in the range of 0 to 65535) for them to be considered similar
+	maxAlphaDifference float64 = 500
 )
 
 // HorizontalLine draws a horizontal line onto an image
@@ -36,10 +39,45 @@ func Rectangle(img *image.RGBA, rectangle image.Rectangle, c color.Color, fillCo
 	VerticalLine(img, rectangle.Min.Y, rectangle.Max.Y, rectangle.Max.X, c)
 }
 
-// Scale scales a given image to the desired dimensions
-func Scale(img *image.RGBA, bounds image.Rectangle, interpolator draw.Interpolator) image.Image {
+// IsOpaque returns whether every pixel of img is fully opaque
+func IsOpaque(img image.Image) bool {
+	// Most image types can check this more efficiently themselves
+	if opaqueImage, ok := img.(interface{ Opaque() bool }); ok {
+		return opaqueImage.Opaque()
+	}
+
+	bounds := img.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			if _, _, _, a := img.At(x, y).RGBA(); a != 0xffff {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// subImager is implemented by all image types of the standard library
+type subImager interface {
+	SubImage(r image.Rectangle) image.Image
+}
+
+// SubImage returns the section bounds of img. The section shares its pixels with img if the type of img supports it, otherwise it is copied.
+func SubImage(img image.Image, bounds image.Rectangle) image.Image {
+	if subImg, ok := img.(subImager); ok {
+		return subImg.SubImage(bounds)
+	}
+
+	copiedImage := NewImageLike(img, bounds)
+	draw.Draw(copiedImage, bounds, img, bounds.Min, draw.Src)
+	return copiedImage
+}
+
+// Scale scales a given image to the desired dimensions, keeping its ColorModel
+func Scale(img image.Image, bounds image.Rectangle, interpolator draw.Interpolator) image.Image {
 	originalBounds := img.Bounds()
-	scaledImage := image.NewRGBA(bounds)
+	scaledImage := NewImageLike(img, bounds)
 
 	// TODO: evaluate other algorithms
 	interpolator.Scale(scaledImage, scaledImage.Bounds(), img, originalBounds, draw.Over, nil)
@@ -56,9 +94,9 @@ type FillConfig struct {
 }
 
 // FillSpace fills everything in img that doesn't fall within nonTransparentImageBounds with the scaled out edges and corners of img when masked by nonTransparentImageBounds
-func FillSpace(img *image.RGBA, nonTransparentImageBounds image.Rectangle) {
-	var copyBaseImage *image.RGBA
-	var scaledImage *image.RGBA
+func FillSpace(img draw.Image, nonTransparentImageBounds image.Rectangle) {
+	var copyBaseImage draw.Image
+	var scaledImage image.Image
 
 	shouldFillRight := nonTransparentImageBounds.Max.X < img.Bounds().Max.X
 	shouldFillUpper := nonTransparentImageBounds.Min.Y > img.Bounds().Min.Y
@@ -121,11 +159,11 @@ func FillSpace(img *image.RGBA, nonTransparentImageBounds image.Rectangle) {
 		// If the current edge doesn't lie right at the edge of img
 		if operation.shouldFill {
 			// Get the current edge or edge point
-			copyBaseImage = image.NewRGBA(operation.copyBounds)
+			copyBaseImage = NewImageLike(img, operation.copyBounds)
 			draw.Draw(copyBaseImage, copyBaseImage.Bounds(), img, copyBaseImage.Bounds().Min, draw.Over)
 
 			// Scale it up towards the img edge
-			scaledImage = Scale(copyBaseImage, operation.scaleBounds, draw.NearestNeighbor).(*image.RGBA)
+			scaledImage = Scale(copyBaseImage, operation.scaleBounds, draw.NearestNeighbor)
 
 			// Copy the scaled image into img
 			draw.Draw(img, operation.scaleBounds, scaledImage, scaledImage.Bounds().Min, draw.Over)
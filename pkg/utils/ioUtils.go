@@ -2,16 +2,28 @@ package utils
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"image"
+	_ "image/gif"
 	"image/jpeg"
 	"image/png"
 	"io"
 	"io/ioutil"
 	"os"
 	"path"
+	"strings"
+
+	"golang.org/x/image/bmp"
+	"golang.org/x/image/tiff"
+
+	// Register additional decoders for image.Decode
+	_ "golang.org/x/image/webp"
 )
 
+// SupportedInputFormats lists the image formats that can be read by ReadImage
+var SupportedInputFormats = []string{"jpeg", "png", "gif", "bmp", "tiff", "webp"}
+
 // ReadImage Takes the path to an image file in the file system and returns the decoded image
 func ReadImage(path string) (img image.Image, err error) {
 	file, err := os.Open(path)
@@ -32,37 +44,123 @@ func ReadImageFromBytes(imageBytes []byte) (img image.Image, err error) {
 // ReadImageFromReader takes an io.Reader and attempts to decode it into an image
 func ReadImageFromReader(reader io.Reader) (img image.Image, err error) {
 	img, _, err = image.Decode(reader)
+	if errors.Is(err, image.ErrFormat) {
+		return img, fmt.Errorf("unsupported image format, supported formats are %s: %w", strings.Join(SupportedInputFormats, ", "), err)
+	}
 	return img, err
 }
 
-// WriteImage encodes an image as either PNG or JPEG according to extension and writes it to writer.
-// Note: extension needs to be prepended with a dot.
-func WriteImage(img image.Image, writer io.Writer, extension string) (err error) {
-	switch extension {
-	case ".jpg":
-		fallthrough
-	case ".jpeg":
-		err = jpeg.Encode(writer, img, nil)
-	case ".png":
-		err = png.Encode(writer, img)
-	default:
-		err = fmt.Errorf("unknown file extension: %q", extension)
+const (
+	// FormatPNG encodes images losslessly as PNG
+	FormatPNG = "png"
+	// FormatJPEG encodes images lossy as JPEG
+	FormatJPEG = "jpeg"
+	// FormatBMP encodes images uncompressed as BMP
+	FormatBMP = "bmp"
+	// FormatTIFF encodes images as TIFF
+	FormatTIFF = "tiff"
+)
+
+// SupportedOutputFormats lists the image formats that can be written by WriteImage
+var SupportedOutputFormats = []string{FormatPNG, FormatJPEG, FormatBMP, FormatTIFF}
+
+// formatAliases maps file extensions and format names to their format
+var formatAliases = map[string]string{
+	"png":  FormatPNG,
+	"jpg":  FormatJPEG,
+	"jpeg": FormatJPEG,
+	"bmp":  FormatBMP,
+	"tif":  FormatTIFF,
+	"tiff": FormatTIFF,
+}
+
+// pngCompressionLevels holds the compression levels of the PNG encoder by name
+var pngCompressionLevels = map[string]png.CompressionLevel{
+	"":                png.DefaultCompression,
+	"Default":         png.DefaultCompression,
+	"None":            png.NoCompression,
+	"BestSpeed":       png.BestSpeed,
+	"BestCompression": png.BestCompression,
+}
+
+// WriteOptions holds settings of the encoders used by WriteImage. Zero values select the defaults of the encoders.
+type WriteOptions struct {
+	// Quality of JPEG images (1 to 100)
+	JPEGQuality int
+	// Compression level of PNG images
+	PNGCompressionLevel png.CompressionLevel
+}
+
+// GetFormat returns the format for a format name or file extension, with or without leading dot
+func GetFormat(formatOrExtension string) (string, error) {
+	format, ok := formatAliases[strings.ToLower(strings.TrimPrefix(formatOrExtension, "."))]
+	if !ok {
+		return "", fmt.Errorf("unsupported output format %q, supported formats are %s", formatOrExtension, strings.Join(SupportedOutputFormats, ", "))
+	}
+	return format, nil
+}
+
+// GetPNGCompressionLevel returns the PNG compression level for a compression level name from pngCompressionLevels
+func GetPNGCompressionLevel(compressionLevelId string) (png.CompressionLevel, error) {
+	compressionLevel, ok := pngCompressionLevels[compressionLevelId]
+	var err error
+	if !ok {
+		err = fmt.Errorf("png compression level id not found: %q", compressionLevelId)
+	}
+	return compressionLevel, err
+}
+
+// WriteImage encodes an image in format and writes it to writer.
+// format can be a format name or a file extension, see GetFormat. options may be nil to use the defaults of the encoders.
+func WriteImage(img image.Image, writer io.Writer, format string, options *WriteOptions) (err error) {
+	format, err = GetFormat(format)
+	if err != nil {
+		return err
+	}
+
+	if options == nil {
+		options = new(WriteOptions)
+	}
+
+	switch format {
+	case FormatJPEG:
+		var jpegOptions *jpeg.Options
+		if options.JPEGQuality > 0 {
+			jpegOptions = &jpeg.Options{Quality: options.JPEGQuality}
+		}
+		err = jpeg.Encode(writer, img, jpegOptions)
+	case FormatPNG:
+		encoder := png.Encoder{CompressionLevel: options.PNGCompressionLevel}
+		err = encoder.Encode(writer, img)
+	case FormatBMP:
+		err = bmp.Encode(writer, img)
+	case FormatTIFF:
+		err = tiff.Encode(writer, img, &tiff.Options{Compression: tiff.Deflate})
 	}
 
 	return err
 }
 
-// WriteImageToFile encodes an image as either PNG or JPEG according to the extension of filePath and writes it to filePath.
-func WriteImageToFile(img image.Image, filePath string) error {
+// WriteImageToFile encodes an image in format and writes it to filePath.
+// An empty format selects the format according to the extension of filePath.
+func WriteImageToFile(img image.Image, filePath string, format string, options *WriteOptions) error {
+	if format == "" {
+		format = path.Ext(filePath)
+	}
+
+	// Validate format before creating the file
+	format, err := GetFormat(format)
+	if err != nil {
+		return err
+	}
+
 	file, err := os.Create(filePath)
 	if err != nil {
 		return err
 	}
 	defer file.Close()
 
-	extension := path.Ext(filePath)
-
-	return WriteImage(img, file, extension)
+	return WriteImage(img, file, format, options)
 }
 
 // WriteFile writes data from an io.Reader to filePath
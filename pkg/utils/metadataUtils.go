@@ -0,0 +1,447 @@
+package utils
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"io/ioutil"
+)
+
+var (
+	jpegSignature = []byte{0xff, 0xd8}
+	pngSignature  = []byte{0x89, 'P', 'N', 'G', '\r', '\n', 0x1a, '\n'}
+	exifHeader    = []byte("Exif\x00\x00")
+	xmpHeader     = []byte("http://ns.adobe.com/xap/1.0/\x00")
+	iccHeader     = []byte("ICC_PROFILE\x00")
+)
+
+const (
+	// JPEG markers relevant for metadata
+	jpegMarkerAPP1 = 0xe1
+	jpegMarkerAPP2 = 0xe2
+	jpegMarkerSOS  = 0xda
+	// Maximal length of the payload of a JPEG segment, excluding the length field itself
+	jpegMaxSegmentLength = 0xffff - 2
+	// Keyword of PNG iTXt chunks that hold XMP packets
+	pngXMPKeyword = "XML:com.adobe.xmp"
+	// EXIF tag holding the orientation of the image
+	exifTagOrientation = 0x0112
+)
+
+// ImageMetadata holds the metadata blocks of an image file that aren't part of its pixels
+type ImageMetadata struct {
+	// EXIF data in TIFF format, without the JPEG APP1 header
+	Exif []byte
+	// ICC color profile
+	ICCProfile []byte
+	// XMP packet
+	XMP []byte
+}
+
+// IsEmpty returns whether m holds no metadata at all
+func (m *ImageMetadata) IsEmpty() bool {
+	return m == nil || (len(m.Exif) == 0 && len(m.ICCProfile) == 0 && len(m.XMP) == 0)
+}
+
+// ReadImageMetadata extracts EXIF, ICC profile and XMP metadata from an encoded JPEG or PNG file.
+// Other formats return empty metadata.
+func ReadImageMetadata(data []byte) (*ImageMetadata, error) {
+	switch {
+	case bytes.HasPrefix(data, jpegSignature):
+		return readJPEGMetadata(data)
+	case bytes.HasPrefix(data, pngSignature):
+		return readPNGMetadata(data)
+	default:
+		return new(ImageMetadata), nil
+	}
+}
+
+// WriteImageMetadata embeds m into an encoded JPEG or PNG file and returns the result.
+// Other formats are returned unchanged.
+func WriteImageMetadata(data []byte, m *ImageMetadata) ([]byte, error) {
+	if m.IsEmpty() {
+		return data, nil
+	}
+
+	switch {
+	case bytes.HasPrefix(data, jpegSignature):
+		return writeJPEGMetadata(data, m)
+	case bytes.HasPrefix(data, pngSignature):
+		return writePNGMetadata(data, m)
+	default:
+		return data, nil
+	}
+}
+
+// readJPEGMetadata walks the segments of a JPEG file up to the image data and collects the metadata segments
+func readJPEGMetadata(data []byte) (*ImageMetadata, error) {
+	m := new(ImageMetadata)
+	iccChunks := make(map[byte][]byte)
+
+	offset := len(jpegSignature)
+	for offset+4 <= len(data) {
+		if data[offset] != 0xff {
+			return nil, fmt.Errorf("expected JPEG marker at offset %d", offset)
+		}
+
+		marker := data[offset+1]
+		// Skip fill bytes
+		if marker == 0xff {
+			offset++
+			continue
+		}
+
+		// Image data follows, no more metadata segments
+		if marker == jpegMarkerSOS {
+			break
+		}
+
+		segmentLength := int(binary.BigEndian.Uint16(data[offset+2:]))
+		if segmentLength < 2 || offset+2+segmentLength > len(data) {
+			return nil, fmt.Errorf("JPEG segment at offset %d exceeds file length", offset)
+		}
+		payload := data[offset+4 : offset+2+segmentLength]
+
+		switch {
+		case marker == jpegMarkerAPP1 && bytes.HasPrefix(payload, exifHeader):
+			m.Exif = append([]byte(nil), payload[len(exifHeader):]...)
+		case marker == jpegMarkerAPP1 && bytes.HasPrefix(payload, xmpHeader):
+			m.XMP = append([]byte(nil), payload[len(xmpHeader):]...)
+		case marker == jpegMarkerAPP2 && bytes.HasPrefix(payload, iccHeader) && len(payload) > len(iccHeader)+2:
+			// ICC profiles are split into numbered chunks
+			sequenceNumber := payload[len(iccHeader)]
+			iccChunks[sequenceNumber] = payload[len(iccHeader)+2:]
+		}
+
+		offset += 2 + segmentLength
+	}
+
+	// Reassemble ICC profile, chunks are numbered starting at 1
+	for i := 1; i <= len(iccChunks); i++ {
+		chunk, ok := iccChunks[byte(i)]
+		if !ok {
+			return nil, fmt.Errorf("ICC profile chunk %d of %d is missing", i, len(iccChunks))
+		}
+		m.ICCProfile = append(m.ICCProfile, chunk...)
+	}
+
+	return m, nil
+}
+
+// writeJPEGMetadata inserts metadata segments right after the start of image marker of a JPEG file
+func writeJPEGMetadata(data []byte, m *ImageMetadata) ([]byte, error) {
+	output := new(bytes.Buffer)
+	output.Write(jpegSignature)
+
+	if len(m.Exif) > 0 {
+		err := writeJPEGSegment(output, jpegMarkerAPP1, append(append([]byte(nil), exifHeader...), m.Exif...))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(m.XMP) > 0 {
+		err := writeJPEGSegment(output, jpegMarkerAPP1, append(append([]byte(nil), xmpHeader...), m.XMP...))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(m.ICCProfile) > 0 {
+		// Split ICC profile into chunks that fit into a segment together with their header
+		chunkLength := jpegMaxSegmentLength - len(iccHeader) - 2
+		chunkCount := (len(m.ICCProfile) + chunkLength - 1) / chunkLength
+		if chunkCount > 255 {
+			return nil, fmt.Errorf("ICC profile of %d bytes is too large for a JPEG file", len(m.ICCProfile))
+		}
+
+		for i := 0; i < chunkCount; i++ {
+			end := (i + 1) * chunkLength
+			if end > len(m.ICCProfile) {
+				end = len(m.ICCProfile)
+			}
+
+			payload := append(append([]byte(nil), iccHeader...), byte(i+1), byte(chunkCount))
+			payload = append(payload, m.ICCProfile[i*chunkLength:end]...)
+
+			err := writeJPEGSegment(output, jpegMarkerAPP2, payload)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	output.Write(data[len(jpegSignature):])
+	return output.Bytes(), nil
+}
+
+// writeJPEGSegment writes a single JPEG segment with marker and payload to output
+func writeJPEGSegment(output *bytes.Buffer, marker byte, payload []byte) error {
+	if len(payload) > jpegMaxSegmentLength {
+		return fmt.Errorf("JPEG segment of %d bytes exceeds the maximal segment length", len(payload))
+	}
+
+	output.Write([]byte{0xff, marker})
+	binary.Write(output, binary.BigEndian, uint16(len(payload)+2))
+	output.Write(payload)
+	return nil
+}
+
+// readPNGMetadata walks the chunks of a PNG file and collects the metadata chunks
+func readPNGMetadata(data []byte) (*ImageMetadata, error) {
+	m := new(ImageMetadata)
+
+	offset := len(pngSignature)
+	for offset+12 <= len(data) {
+		chunkLength := int(binary.BigEndian.Uint32(data[offset:]))
+		chunkType := string(data[offset+4 : offset+8])
+		if offset+12+chunkLength > len(data) {
+			return nil, fmt.Errorf("PNG chunk %s at offset %d exceeds file length", chunkType, offset)
+		}
+		chunkData := data[offset+8 : offset+8+chunkLength]
+
+		switch chunkType {
+		case "eXIf":
+			m.Exif = append([]byte(nil), chunkData...)
+		case "iCCP":
+			// Profile name, null separator, compression method, zlib compressed profile
+			nameEnd := bytes.IndexByte(chunkData, 0)
+			if nameEnd < 0 || nameEnd+2 > len(chunkData) {
+				return nil, fmt.Errorf("malformed PNG iCCP chunk")
+			}
+
+			profile, err := decompress(chunkData[nameEnd+2:])
+			if err != nil {
+				return nil, err
+			}
+			m.ICCProfile = profile
+		case "iTXt":
+			xmp, ok, err := readPNGXMP(chunkData)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				m.XMP = xmp
+			}
+		case "IEND":
+			return m, nil
+		}
+
+		offset += 12 + chunkLength
+	}
+
+	return m, nil
+}
+
+// readPNGXMP returns the text of an iTXt chunk if it holds an XMP packet
+func readPNGXMP(chunkData []byte) ([]byte, bool, error) {
+	// Keyword, null separator, compression flag, compression method, language tag, null separator, translated keyword, null separator, text
+	fields := bytes.SplitN(chunkData, []byte{0}, 2)
+	if len(fields) != 2 || string(fields[0]) != pngXMPKeyword || len(fields[1]) < 2 {
+		return nil, false, nil
+	}
+
+	compressed := fields[1][0] == 1
+	rest := bytes.SplitN(fields[1][2:], []byte{0}, 3)
+	if len(rest) != 3 {
+		return nil, false, fmt.Errorf("malformed PNG iTXt chunk")
+	}
+
+	if !compressed {
+		return append([]byte(nil), rest[2]...), true, nil
+	}
+
+	text, err := decompress(rest[2])
+	return text, true, err
+}
+
+// writePNGMetadata inserts metadata chunks right after the header chunk of a PNG file
+func writePNGMetadata(data []byte, m *ImageMetadata) ([]byte, error) {
+	// The IHDR chunk always comes first
+	headerEnd := len(pngSignature) + 12 + int(binary.BigEndian.Uint32(data[len(pngSignature):]))
+	if headerEnd > len(data) {
+		return nil, fmt.Errorf("PNG header chunk exceeds file length")
+	}
+
+	output := new(bytes.Buffer)
+	output.Write(data[:headerEnd])
+
+	if len(m.ICCProfile) > 0 {
+		compressedProfile := new(bytes.Buffer)
+		zlibWriter := zlib.NewWriter(compressedProfile)
+		zlibWriter.Write(m.ICCProfile)
+		zlibWriter.Close()
+
+		// Profile name, null separator, compression method 0 (zlib)
+		chunkData := append([]byte("ICC Profile\x00\x00"), compressedProfile.Bytes()...)
+		writePNGChunk(output, "iCCP", chunkData)
+	}
+
+	if len(m.Exif) > 0 {
+		writePNGChunk(output, "eXIf", m.Exif)
+	}
+
+	if len(m.XMP) > 0 {
+		// Keyword, null separator, uncompressed, no language tag and no translated keyword
+		chunkData := append([]byte(pngXMPKeyword+"\x00\x00\x00\x00\x00"), m.XMP...)
+		writePNGChunk(output, "iTXt", chunkData)
+	}
+
+	output.Write(data[headerEnd:])
+	return output.Bytes(), nil
+}
+
+// writePNGChunk writes a single PNG chunk with its checksum to output
+func writePNGChunk(output *bytes.Buffer, chunkType string, chunkData []byte) {
+	binary.Write(output, binary.BigEndian, uint32(len(chunkData)))
+
+	checksum := crc32.NewIEEE()
+	checksum.Write([]byte(chunkType))
+	checksum.Write(chunkData)
+
+	output.Write([]byte(chunkType))
+	output.Write(chunkData)
+	binary.Write(output, binary.BigEndian, checksum.Sum32())
+}
+
+// decompress inflates zlib compressed data
+func decompress(data []byte) ([]byte, error) {
+	zlibReader, err := zlib.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer zlibReader.Close()
+
+	return ioutil.ReadAll(zlibReader)
+}
+
+// getOrientationOffset returns the offset of the orientation value inside of the EXIF data and the byte order of the EXIF data.
+// The offset is negative if the EXIF data contains no orientation.
+func (m *ImageMetadata) getOrientationOffset() (int, binary.ByteOrder) {
+	exif := m.Exif
+	if len(exif) < 8 {
+		return -1, nil
+	}
+
+	// TIFF header: byte order, magic number 42, offset of the first image file directory
+	var byteOrder binary.ByteOrder
+	switch string(exif[:2]) {
+	case "II":
+		byteOrder = binary.LittleEndian
+	case "MM":
+		byteOrder = binary.BigEndian
+	default:
+		return -1, nil
+	}
+
+	directoryOffset := int(byteOrder.Uint32(exif[4:]))
+	if directoryOffset+2 > len(exif) {
+		return -1, nil
+	}
+
+	// Each directory entry consists of tag, type, count and value
+	entryCount := int(byteOrder.Uint16(exif[directoryOffset:]))
+	for i := 0; i < entryCount; i++ {
+		entryOffset := directoryOffset + 2 + i*12
+		if entryOffset+12 > len(exif) {
+			return -1, nil
+		}
+
+		if byteOrder.Uint16(exif[entryOffset:]) == exifTagOrientation {
+			return entryOffset + 8, byteOrder
+		}
+	}
+
+	return -1, nil
+}
+
+// Orientation returns the EXIF orientation (1 to 8) of the image, 1 if none is set
+func (m *ImageMetadata) Orientation() int {
+	offset, byteOrder := m.getOrientationOffset()
+	if offset < 0 {
+		return 1
+	}
+
+	orientation := int(byteOrder.Uint16(m.Exif[offset:]))
+	if orientation < 1 || orientation > 8 {
+		return 1
+	}
+	return orientation
+}
+
+// SetOrientation overwrites the EXIF orientation of the image if the EXIF data contains one
+func (m *ImageMetadata) SetOrientation(orientation int) {
+	offset, byteOrder := m.getOrientationOffset()
+	if offset < 0 {
+		return
+	}
+
+	byteOrder.PutUint16(m.Exif[offset:], uint16(orientation))
+}
+
+// InverseOrientation returns the EXIF orientation that undoes orientation
+func InverseOrientation(orientation int) int {
+	// Rotations by 90 degrees undo each other, all other orientations undo themselves
+	switch orientation {
+	case 6:
+		return 8
+	case 8:
+		return 6
+	default:
+		return orientation
+	}
+}
+
+// ApplyOrientation returns a copy of img that is rotated and flipped according to an EXIF orientation, so that it can be displayed without it
+func ApplyOrientation(img image.Image, orientation int) image.Image {
+	if orientation <= 1 || orientation > 8 {
+		return img
+	}
+
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+
+	// Orientations 5 to 8 swap width and height
+	orientedBounds := image.Rect(0, 0, width, height)
+	if orientation >= 5 {
+		orientedBounds = image.Rect(0, 0, height, width)
+	}
+	orientedImage := NewImageLike(img, orientedBounds)
+
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			var orientedX, orientedY int
+
+			switch orientation {
+			case 2:
+				// Flipped horizontally
+				orientedX, orientedY = width-1-x, y
+			case 3:
+				// Rotated by 180 degrees
+				orientedX, orientedY = width-1-x, height-1-y
+			case 4:
+				// Flipped vertically
+				orientedX, orientedY = x, height-1-y
+			case 5:
+				// Transposed
+				orientedX, orientedY = y, x
+			case 6:
+				// Rotated by 90 degrees clockwise
+				orientedX, orientedY = height-1-y, x
+			case 7:
+				// Transversed
+				orientedX, orientedY = height-1-y, width-1-x
+			case 8:
+				// Rotated by 90 degrees counterclockwise
+				orientedX, orientedY = y, width-1-x
+			}
+
+			orientedImage.Set(orientedX, orientedY, img.At(bounds.Min.X+x, bounds.Min.Y+y))
+		}
+	}
+
+	return orientedImage
+}
@@ -0,0 +1,215 @@
+package utils
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"io"
+	"os"
+	"strconv"
+)
+
+// PNMExtensions lists the file extensions of the binary PNM files that can be read by OpenPNM
+var PNMExtensions = []string{".pgm", ".ppm", ".pnm"}
+
+// SupportedTiledInputFormats lists the image formats that can be read tile by tile by OpenPNM in addition to SupportedInputFormats.
+// ReadImage can't read them, so they can only be encoded tile by tile.
+var SupportedTiledInputFormats = []string{"pgm", "ppm"}
+
+// PNMFile reads sections of binary PGM (P5) and PPM (P6) files without loading the whole image.
+// Images with a maximal value of 255 are read as 8-bit images, images with a maximal value of 65535 as 16-bit images.
+type PNMFile struct {
+	file *os.File
+	// Dimensions of the image
+	width  int
+	height int
+	// Number of channels per pixel, 1 for PGM and 3 for PPM
+	channels int
+	// Number of bytes per channel
+	channelSize int
+	// Position of the first pixel in the file
+	dataOffset int64
+}
+
+// OpenPNM opens the binary PGM or PPM file at path and parses its header
+func OpenPNM(path string) (*PNMFile, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &PNMFile{file: file}
+	err = p.readHeader()
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return p, nil
+}
+
+// readHeader parses the magic number, dimensions and maximal value of the file
+func (p *PNMFile) readHeader() error {
+	reader := bufio.NewReader(p.file)
+	// Count the bytes of the header to find the pixel data
+	var headerLength int64
+
+	// readToken returns the next whitespace-separated token, skipping comments
+	readToken := func() (string, error) {
+		token := make([]byte, 0, 8)
+		isComment := false
+		for {
+			b, err := reader.ReadByte()
+			if err != nil {
+				return "", err
+			}
+			headerLength++
+
+			switch {
+			case isComment:
+				isComment = b != '\n' && b != '\r'
+			case b == '#' && len(token) == 0:
+				isComment = true
+			case b == ' ' || b == '\t' || b == '\n' || b == '\r' || b == '\v' || b == '\f':
+				// Exactly one whitespace character follows the last token before the pixel data
+				if len(token) > 0 {
+					return string(token), nil
+				}
+			default:
+				token = append(token, b)
+			}
+		}
+	}
+
+	// readNumber returns the next token as positive number
+	readNumber := func(name string) (int, error) {
+		token, err := readToken()
+		if err != nil {
+			return 0, err
+		}
+
+		number, err := strconv.Atoi(token)
+		if err != nil || number <= 0 {
+			return 0, fmt.Errorf("invalid %s: %q", name, token)
+		}
+		return number, nil
+	}
+
+	magicNumber, err := readToken()
+	if err != nil {
+		return err
+	}
+
+	switch magicNumber {
+	case "P5":
+		p.channels = 1
+	case "P6":
+		p.channels = 3
+	default:
+		return fmt.Errorf("unsupported PNM type %q, only binary PGM (P5) and PPM (P6) are supported", magicNumber)
+	}
+
+	if p.width, err = readNumber("width"); err != nil {
+		return err
+	}
+	if p.height, err = readNumber("height"); err != nil {
+		return err
+	}
+
+	maxValue, err := readNumber("maximal value")
+	if err != nil {
+		return err
+	}
+
+	switch maxValue {
+	case 0xff:
+		p.channelSize = 1
+	case 0xffff:
+		p.channelSize = 2
+	default:
+		return fmt.Errorf("unsupported maximal value %d, only 255 and 65535 are supported", maxValue)
+	}
+
+	p.dataOffset = headerLength
+
+	// Catch truncated files before reading any tiles
+	info, err := p.file.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() < p.dataOffset+int64(p.height)*p.getRowLength() {
+		return io.ErrUnexpectedEOF
+	}
+
+	return nil
+}
+
+// Bounds returns the bounds of the image
+func (p *PNMFile) Bounds() image.Rectangle {
+	return image.Rect(0, 0, p.width, p.height)
+}
+
+// ColorModel returns the ColorModel the tiles of the image are read in
+func (p *PNMFile) ColorModel() ColorModel {
+	switch {
+	case p.channels == 1 && p.channelSize == 1:
+		return ColorModelGray
+	case p.channels == 1:
+		return ColorModelGray16
+	case p.channelSize == 1:
+		return ColorModelRGBA
+	default:
+		return ColorModelRGBA64
+	}
+}
+
+// ReadTile reads the section rect of the image, reading only the rows that rect covers
+func (p *PNMFile) ReadTile(rect image.Rectangle) (image.Image, error) {
+	if !rect.In(p.Bounds()) {
+		return nil, fmt.Errorf("tile %v is not within the bounds of the image %v", rect, p.Bounds())
+	}
+
+	tile := NewImage(p.ColorModel(), rect)
+	pixelSize := p.channels * p.channelSize
+	row := make([]byte, rect.Dx()*pixelSize)
+
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		offset := p.dataOffset + int64(y)*p.getRowLength() + int64(rect.Min.X*pixelSize)
+		_, err := p.file.ReadAt(row, offset)
+		if err != nil {
+			return nil, err
+		}
+
+		switch tile := tile.(type) {
+		case *image.Gray:
+			copy(tile.Pix[tile.PixOffset(rect.Min.X, y):], row)
+		case *image.Gray16:
+			copy(tile.Pix[tile.PixOffset(rect.Min.X, y):], row)
+		case *image.RGBA:
+			pix := tile.Pix[tile.PixOffset(rect.Min.X, y):]
+			for x := 0; x < rect.Dx(); x++ {
+				copy(pix[4*x:4*x+3], row[3*x:3*x+3])
+				pix[4*x+3] = 0xff
+			}
+		case *image.RGBA64:
+			pix := tile.Pix[tile.PixOffset(rect.Min.X, y):]
+			for x := 0; x < rect.Dx(); x++ {
+				copy(pix[8*x:8*x+6], row[6*x:6*x+6])
+				pix[8*x+6] = 0xff
+				pix[8*x+7] = 0xff
+			}
+		}
+	}
+
+	return tile, nil
+}
+
+// Close closes the underlying file
+func (p *PNMFile) Close() error {
+	return p.file.Close()
+}
+
+// getRowLength returns the number of bytes of a row of pixels
+func (p *PNMFile) getRowLength() int64 {
+	return int64(p.width * p.channels * p.channelSize)
+}